@@ -41,25 +41,19 @@ func (c *Client) GetAccount(ctx context.Context, accountID string) (*Account, er
 
 // ListAccounts retrieves all accounts
 func (c *Client) ListAccounts(ctx context.Context, limit int) ([]Account, error) {
-	path := "/api/v1/accounts"
-	if limit > 0 {
-		path = fmt.Sprintf("%s?limit=%d", path, limit)
-	}
-
-	resp, err := c.doRequest(ctx, "GET", path, nil)
-	if err != nil {
-		return nil, err
-	}
+	return collectPage(c.ListAccountsPage(ctx, PageOptions{Limit: limit}))
+}
 
-	var result struct {
-		Items []Account `json:"items"`
-		Total int       `json:"total"`
-	}
-	if err := c.parseResponse(resp, &result); err != nil {
-		return nil, err
-	}
+// ListAccountsPage retrieves one page of accounts
+func (c *Client) ListAccountsPage(ctx context.Context, opts PageOptions) (*Page[Account], error) {
+	return getPage[Account](ctx, c, "/api/v1/accounts", nil, opts)
+}
 
-	return result.Items, nil
+// IterateAccounts walks all accounts, opts.Limit at a time
+func (c *Client) IterateAccounts(ctx context.Context, opts PageOptions) *Iterator[Account] {
+	return newIterator(ctx, opts, func(ctx context.Context, opts PageOptions) (*Page[Account], error) {
+		return c.ListAccountsPage(ctx, opts)
+	})
 }
 
 // UpdateAccount updates an existing account
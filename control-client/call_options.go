@@ -0,0 +1,56 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import "context"
+
+// CallOption adjusts how a single call is made. Client methods take no
+// options of their own, so attach them to the call's context:
+//
+//	ctx = controlclient.WithCallOptions(ctx, controlclient.RetryNonIdempotent())
+//	job, err := client.CreatePiperJob(ctx, job)
+type CallOption func(*callOptions)
+
+// callOptions holds the per-call settings carried in a context
+type callOptions struct {
+	retry              *RetryPolicy
+	retryNonIdempotent bool
+	idempotencyKey     string
+	bypassCache        bool
+}
+
+type callOptionsKey struct{}
+
+// WithCallOptions returns a context carrying opts, added to any options ctx
+// already carries
+func WithCallOptions(ctx context.Context, opts ...CallOption) context.Context {
+	co := callOptionsFromContext(ctx)
+	for _, opt := range opts {
+		opt(&co)
+	}
+	return context.WithValue(ctx, callOptionsKey{}, co)
+}
+
+func callOptionsFromContext(ctx context.Context) callOptions {
+	if co, ok := ctx.Value(callOptionsKey{}).(callOptions); ok {
+		return co
+	}
+	return callOptions{}
+}
+
+// OverrideRetryPolicy uses policy instead of the client's retry policy
+func OverrideRetryPolicy(policy RetryPolicy) CallOption {
+	return func(co *callOptions) {
+		p := policy.withDefaults()
+		co.retry = &p
+	}
+}
+
+// RetryNonIdempotent lets a POST be retried. Only use it for calls that are
+// safe to repeat, such as lock acquisition with a fixed owner.
+func RetryNonIdempotent() CallOption {
+	return func(co *callOptions) {
+		co.retryNonIdempotent = true
+	}
+}
@@ -0,0 +1,187 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through and counts failures
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails requests immediately with ErrCircuitOpen
+	CircuitOpen
+	// CircuitHalfOpen lets a few trial requests through to see whether the
+	// service has recovered
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// CircuitBreakerConfig configures the circuit breakers, one per endpoint
+// group. Network errors and 5xx responses count as failures.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// circuit (default 5)
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before trial requests
+	// are let through (default 30s)
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of trial requests allowed at once while
+	// half-open, all of which must succeed to close the circuit (default 1)
+	HalfOpenRequests int
+	// OnStateChange is called after a circuit changes state, for logging and
+	// metrics. It must not block.
+	OnStateChange func(group string, from, to CircuitState)
+}
+
+func (c CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = 5
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = 30 * time.Second
+	}
+	if c.HalfOpenRequests <= 0 {
+		c.HalfOpenRequests = 1
+	}
+	return c
+}
+
+// breakerOutcome is how a request that got through the breaker ended
+type breakerOutcome int
+
+const (
+	outcomeSuccess breakerOutcome = iota
+	outcomeFailure
+	// outcomeIgnored is for requests that say nothing about the service's
+	// health, such as ones the caller cancelled
+	outcomeIgnored
+)
+
+// circuitBreakers tracks one circuit per endpoint group
+type circuitBreakers struct {
+	config   CircuitBreakerConfig
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state     CircuitState
+	failures  int
+	openedAt  time.Time
+	inFlight  int
+	successes int
+}
+
+func newCircuitBreakers(config CircuitBreakerConfig) *circuitBreakers {
+	return &circuitBreakers{
+		config:   config.withDefaults(),
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// state returns the current state of group's circuit
+func (b *circuitBreakers) state(group string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[group]
+	if !ok {
+		return CircuitClosed
+	}
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= b.config.OpenTimeout {
+		return CircuitHalfOpen
+	}
+	return c.state
+}
+
+// allow asks to send a request to group. On success the returned function
+// must be called with the outcome once the request is done.
+func (b *circuitBreakers) allow(group string) (func(breakerOutcome), error) {
+	b.mu.Lock()
+	c, ok := b.circuits[group]
+	if !ok {
+		c = &circuit{}
+		b.circuits[group] = c
+	}
+
+	from := c.state
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= b.config.OpenTimeout {
+		c.state = CircuitHalfOpen
+		c.inFlight = 0
+		c.successes = 0
+	}
+
+	if c.state == CircuitOpen || (c.state == CircuitHalfOpen && c.inFlight >= b.config.HalfOpenRequests) {
+		to := c.state
+		b.mu.Unlock()
+		b.notify(group, from, to)
+		return nil, fmt.Errorf("%w: %s endpoints", ErrCircuitOpen, group)
+	}
+	if c.state == CircuitHalfOpen {
+		c.inFlight++
+	}
+	to := c.state
+	b.mu.Unlock()
+	b.notify(group, from, to)
+
+	return func(outcome breakerOutcome) {
+		b.record(group, c, to == CircuitHalfOpen, outcome)
+	}, nil
+}
+
+// record updates group's circuit with the outcome of a request. trial is set
+// for requests let through while half-open.
+func (b *circuitBreakers) record(group string, c *circuit, trial bool, outcome breakerOutcome) {
+	b.mu.Lock()
+	from := c.state
+
+	if trial && c.state == CircuitHalfOpen {
+		c.inFlight--
+	}
+
+	switch {
+	case outcome == outcomeIgnored:
+	case c.state == CircuitHalfOpen && trial:
+		if outcome == outcomeFailure {
+			c.state = CircuitOpen
+			c.openedAt = time.Now()
+		} else if c.successes++; c.successes >= b.config.HalfOpenRequests {
+			c.state = CircuitClosed
+			c.failures = 0
+		}
+	case c.state == CircuitClosed:
+		if outcome == outcomeSuccess {
+			c.failures = 0
+		} else if c.failures++; c.failures >= b.config.FailureThreshold {
+			c.state = CircuitOpen
+			c.openedAt = time.Now()
+		}
+	}
+
+	to := c.state
+	b.mu.Unlock()
+	b.notify(group, from, to)
+}
+
+func (b *circuitBreakers) notify(group string, from, to CircuitState) {
+	if from != to && b.config.OnStateChange != nil {
+		b.config.OnStateChange(group, from, to)
+	}
+}
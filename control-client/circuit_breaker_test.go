@@ -0,0 +1,199 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// transitionRecorder collects OnStateChange calls
+type transitionRecorder struct {
+	mu          sync.Mutex
+	transitions []string
+}
+
+func (r *transitionRecorder) record(group string, from, to CircuitState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.transitions = append(r.transitions, group+": "+from.String()+" -> "+to.String())
+}
+
+func (r *transitionRecorder) expect(t *testing.T, want ...string) {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.transitions) != len(want) {
+		t.Fatalf("expected transitions %q, got %q", want, r.transitions)
+	}
+	for i := range want {
+		if r.transitions[i] != want[i] {
+			t.Fatalf("expected transitions %q, got %q", want, r.transitions)
+		}
+	}
+}
+
+// mustAllow asks the breaker to let a request through and fails the test if
+// it doesn't
+func mustAllow(t *testing.T, b *circuitBreakers, group string) func(breakerOutcome) {
+	t.Helper()
+	done, err := b.allow(group)
+	if err != nil {
+		t.Fatalf("expected the request to be allowed, got %v", err)
+	}
+	return done
+}
+
+func mustReject(t *testing.T, b *circuitBreakers, group string) {
+	t.Helper()
+	if _, err := b.allow(group); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	var rec transitionRecorder
+	b := newCircuitBreakers(CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      50 * time.Millisecond,
+		OnStateChange:    rec.record,
+	})
+
+	mustAllow(t, b, "locks")(outcomeFailure)
+	if s := b.state("locks"); s != CircuitClosed {
+		t.Fatalf("expected closed after one failure, got %s", s)
+	}
+	mustAllow(t, b, "locks")(outcomeFailure)
+	if s := b.state("locks"); s != CircuitOpen {
+		t.Fatalf("expected open after two failures, got %s", s)
+	}
+	mustReject(t, b, "locks")
+
+	// Other groups are unaffected
+	mustAllow(t, b, "jobs")(outcomeSuccess)
+
+	time.Sleep(60 * time.Millisecond)
+	if s := b.state("locks"); s != CircuitHalfOpen {
+		t.Fatalf("expected half-open after the timeout, got %s", s)
+	}
+	mustAllow(t, b, "locks")(outcomeSuccess)
+	if s := b.state("locks"); s != CircuitClosed {
+		t.Fatalf("expected closed after a successful trial, got %s", s)
+	}
+
+	rec.expect(t,
+		"locks: closed -> open",
+		"locks: open -> half-open",
+		"locks: half-open -> closed",
+	)
+}
+
+func TestCircuitBreakerSuccessResetsFailures(t *testing.T) {
+	b := newCircuitBreakers(CircuitBreakerConfig{FailureThreshold: 2})
+
+	mustAllow(t, b, "locks")(outcomeFailure)
+	mustAllow(t, b, "locks")(outcomeSuccess)
+	mustAllow(t, b, "locks")(outcomeFailure)
+	if s := b.state("locks"); s != CircuitClosed {
+		t.Fatalf("expected failures to be counted consecutively, got %s", s)
+	}
+}
+
+func TestCircuitBreakerIgnoredOutcome(t *testing.T) {
+	b := newCircuitBreakers(CircuitBreakerConfig{FailureThreshold: 1})
+
+	mustAllow(t, b, "locks")(outcomeIgnored)
+	if s := b.state("locks"); s != CircuitClosed {
+		t.Fatalf("expected an ignored outcome not to count, got %s", s)
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbeLimit(t *testing.T) {
+	var rec transitionRecorder
+	b := newCircuitBreakers(CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenTimeout:      20 * time.Millisecond,
+		HalfOpenRequests: 2,
+		OnStateChange:    rec.record,
+	})
+
+	mustAllow(t, b, "jobs")(outcomeFailure)
+	time.Sleep(30 * time.Millisecond)
+
+	first := mustAllow(t, b, "jobs")
+	second := mustAllow(t, b, "jobs")
+	mustReject(t, b, "jobs")
+
+	// Every trial must succeed before the circuit closes
+	first(outcomeSuccess)
+	if s := b.state("jobs"); s != CircuitHalfOpen {
+		t.Fatalf("expected half-open after one of two trials, got %s", s)
+	}
+	second(outcomeSuccess)
+	if s := b.state("jobs"); s != CircuitClosed {
+		t.Fatalf("expected closed after both trials, got %s", s)
+	}
+
+	rec.expect(t,
+		"jobs: closed -> open",
+		"jobs: open -> half-open",
+		"jobs: half-open -> closed",
+	)
+}
+
+func TestCircuitBreakerFailedTrialReopens(t *testing.T) {
+	var rec transitionRecorder
+	b := newCircuitBreakers(CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenTimeout:      20 * time.Millisecond,
+		OnStateChange:    rec.record,
+	})
+
+	mustAllow(t, b, "jobs")(outcomeFailure)
+	time.Sleep(30 * time.Millisecond)
+	mustAllow(t, b, "jobs")(outcomeFailure)
+	if s := b.state("jobs"); s != CircuitOpen {
+		t.Fatalf("expected a failed trial to reopen the circuit, got %s", s)
+	}
+	mustReject(t, b, "jobs")
+
+	rec.expect(t,
+		"jobs: closed -> open",
+		"jobs: open -> half-open",
+		"jobs: half-open -> open",
+	)
+}
+
+func TestCircuitBreakerClient(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	c := NewClient(Config{
+		BaseURL:        srv.URL,
+		Retry:          fastRetries,
+		CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 2},
+	})
+
+	// The first call's attempts open the circuit, which stops the retries
+	if err := c.HealthCheck(context.Background()); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen once the circuit opens, got %v", err)
+	}
+	if srv.Calls() != 2 {
+		t.Fatalf("expected 2 attempts before the circuit opened, got %d", srv.Calls())
+	}
+	if s := c.CircuitState(EndpointGroupHealth); s != CircuitOpen {
+		t.Fatalf("expected the health circuit to be open, got %s", s)
+	}
+
+	if err := c.HealthCheck(context.Background()); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if srv.Calls() != 2 {
+		t.Fatalf("expected no request while the circuit is open, got %d", srv.Calls()-2)
+	}
+}
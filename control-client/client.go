@@ -6,55 +6,315 @@ package controlclient
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/bytedance/sonic"
 )
 
+// maxErrorBodyBytes caps how much of a response body is quoted in an error
+const maxErrorBodyBytes = 1024
+
 // Client represents a ByteFreezer Control Service client
 type Client struct {
-	baseURL    string
-	httpClient *http.Client
-	apiKey     string
+	baseURL     string
+	httpClient  *http.Client
+	credentials CredentialsProvider
+	retry       RetryPolicy
+	breakers    *circuitBreakers
+	limiters    *limiters
+	headers     http.Header
+	middleware  []Middleware
+	logger      *slog.Logger
+	metrics     MetricsRecorder
+
+	maxResponseBytes int64
+	retryPosts       bool
+	compressor       *compressor
+	endpoints        *endpointPool
+	cache            *responseCache
+	configErr        error
 }
 
 // Config represents client configuration
 type Config struct {
-	BaseURL        string
+	BaseURL string
+	// APIKey is sent as a bearer token. It is ignored if Credentials is set.
 	APIKey         string
 	TimeoutSeconds int
+	// Credentials supplies the bearer token for each request, for keys that
+	// rotate or come from OAuth2
+	Credentials CredentialsProvider
+	// Retry controls retries of failed requests; the zero value uses the
+	// defaults described on RetryPolicy
+	Retry RetryPolicy
+	// CircuitBreaker enables per-endpoint-group circuit breakers when set
+	CircuitBreaker *CircuitBreakerConfig
+	// Limits applies client-side rate and concurrency limits to all requests
+	Limits LimitConfig
+	// GroupLimits applies further limits per endpoint group, keyed by the
+	// EndpointGroup constants. A request must satisfy both.
+	GroupLimits map[string]LimitConfig
+	// TLS configures the CA bundle, client certificate for mutual TLS,
+	// server name and minimum version for HTTPS connections
+	TLS *TLSConfig
+	// Logger, if set, logs every call at DEBUG with its method, path,
+	// status, duration_ms, request_id and trace_id as attributes. A
+	// goodies/log Logger's Slog method gives one.
+	Logger *slog.Logger
+	// Metrics receives request measurements (default: a new
+	// PrometheusMetrics, available from Client.Metrics)
+	Metrics MetricsRecorder
+	// MaxResponseBytes is the largest response body the client will read
+	// (default 256 MiB). Larger ones fail with ErrResponseTooLarge.
+	MaxResponseBytes int64
+	// Compression, if set, compresses request bodies above a size threshold.
+	// Compressed responses are always accepted and decoded.
+	Compression *CompressionConfig
+	// RetryPostsWithIdempotencyKey lets POST calls be retried like PUT ones.
+	// Every POST and PUT carries an Idempotency-Key, so set this when the
+	// Control Service honors it and a repeated POST can't create duplicates.
+	RetryPostsWithIdempotencyKey bool
+	// Failover, if set, spreads requests over several Control Service
+	// replicas, listed or found by DNS SRV, and fails over between them
+	Failover *FailoverConfig
+	// Cache, if set, caches GET responses by ETag and Last-Modified and
+	// revalidates them with conditional requests
+	Cache *CacheConfig
 }
 
-// NewClient creates a new Control Service client
-func NewClient(config Config) *Client {
+// NewClient creates a new Control Service client. Options can customize the
+// HTTP client and transport beyond what Config covers.
+func NewClient(config Config, opts ...Option) *Client {
 	if config.TimeoutSeconds == 0 {
 		config.TimeoutSeconds = 30
 	}
+	if config.MaxResponseBytes <= 0 {
+		config.MaxResponseBytes = 256 << 20
+	}
 
-	return &Client{
-		baseURL: config.BaseURL,
-		apiKey:  config.APIKey,
+	c := &Client{
+		baseURL:     config.BaseURL,
+		credentials: config.Credentials,
 		httpClient: &http.Client{
 			Timeout: time.Duration(config.TimeoutSeconds) * time.Second,
 		},
+		retry:    config.Retry.withDefaults(),
+		logger:   config.Logger,
+		metrics:  config.Metrics,
+		limiters: newLimiters(config.Limits, config.GroupLimits),
+
+		maxResponseBytes: config.MaxResponseBytes,
+		retryPosts:       config.RetryPostsWithIdempotencyKey,
+	}
+	if c.metrics == nil {
+		c.metrics = NewPrometheusMetrics("")
+	}
+	if c.credentials == nil && config.APIKey != "" {
+		c.credentials = StaticCredentials(config.APIKey)
+	}
+	if config.Cache != nil {
+		c.cache = newResponseCache(*config.Cache)
+	}
+	if config.CircuitBreaker != nil {
+		c.breakers = newCircuitBreakers(*config.CircuitBreaker)
+	}
+	if config.Compression != nil {
+		// Like a bad TLS setup below, this fails every request, as NewClient
+		// can't return an error
+		comp, err := newCompressor(*config.Compression)
+		if err != nil {
+			c.configErr = fmt.Errorf("invalid compression configuration: %w", err)
+		}
+		c.compressor = comp
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	if config.TLS != nil {
+		// Applied after the options, so a transport they supply gets it too
+		c.applyTLS(*config.TLS)
+	}
+	c.applyMiddleware()
+
+	if config.Failover != nil {
+		c.endpoints = newEndpointPool(config.BaseURL, *config.Failover)
+		go c.probeLoop()
+	}
+
+	return c
+}
+
+// CircuitState returns the state of the circuit breaker for an endpoint group,
+// such as EndpointGroupLocks. It is always CircuitClosed when circuit breaking
+// is not enabled.
+func (c *Client) CircuitState(group string) CircuitState {
+	if c.breakers == nil {
+		return CircuitClosed
 	}
+	return c.breakers.state(group)
 }
 
-// doRequest performs an HTTP request with proper headers
+// doRequest performs an HTTP request with proper headers, retrying according
+// to the client's retry policy and any per-call options in ctx. Errors carry
+// the call's request ID.
 func (c *Client) doRequest(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
-	var reqBody io.Reader
+	ctx, trace := withRequestTrace(ctx)
+	start := time.Now()
+
+	var resp *http.Response
+	var err error
+	if c.cache != nil && method == http.MethodGet {
+		resp, err = c.cachedGet(ctx, path)
+	} else {
+		resp, err = c.retryRequest(ctx, method, path, body)
+	}
+	c.logRequest(ctx, trace, method, path, resp, err, time.Since(start))
+	if err != nil {
+		return nil, fmt.Errorf("%w (request_id=%s)", err, trace.requestID)
+	}
+	return resp, nil
+}
+
+// retryRequest makes attempts at a request until one succeeds or the retry
+// policy gives up
+func (c *Client) retryRequest(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var reqBody *requestBody
 	if body != nil {
 		jsonData, err := sonic.Marshal(body)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
-		reqBody = bytes.NewBuffer(jsonData)
+		reqBody, err = c.compressor.body(jsonData)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	url := c.baseURL + path
+	opts := callOptionsFromContext(ctx)
+	policy := c.retry
+	if opts.retry != nil {
+		policy = *opts.retry
+	}
+	attempts := policy.MaxAttempts
+	retryable := isIdempotent(method) || opts.retryNonIdempotent ||
+		(c.retryPosts && needsIdempotencyKey(method))
+	if !retryable {
+		attempts = 1
+	}
+
+	// Every attempt carries the same key, so the server can tell a retry
+	// from a new call
+	ctx = withIdempotencyKey(ctx, method, opts)
+
+	for attempt := 1; ; attempt++ {
+		resp, err := c.sendAuthenticated(ctx, method, path, reqBody)
+		if attempt >= attempts || !shouldRetry(ctx, resp, err) {
+			return resp, err
+		}
+
+		delay := policy.backoff(attempt)
+		if resp != nil {
+			if ra, ok := retryAfter(resp); ok {
+				if ra > policy.MaxDelay {
+					// The server wants us to back off for longer than we're
+					// prepared to wait, so hand back its answer
+					return resp, nil
+				}
+				delay = max(delay, ra)
+			}
+			discardResponse(resp)
+		}
+
+		c.metrics.RequestRetried(method, routeTemplate(path))
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, fmt.Errorf("request failed: %w", err)
+		}
+	}
+}
+
+// send makes a single attempt at a request, within the client's limits and
+// circuit breakers. With failover the attempt may try several endpoints, and
+// only its final outcome counts.
+func (c *Client) send(ctx context.Context, method, path string, body *requestBody) (*http.Response, error) {
+	if c.configErr != nil {
+		return nil, &permanentError{c.configErr}
+	}
+
+	group := endpointGroup(path)
+
+	release := func() {}
+	var err error
+	if c.limiters != nil {
+		release, err = c.limiters.acquire(ctx, group)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var done func(breakerOutcome)
+	if c.breakers != nil {
+		done, err = c.breakers.allow(group)
+		if err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	m := RequestMetrics{Method: method, Route: routeTemplate(path)}
+	if body != nil {
+		m.BytesSent, m.UncompressedBytesSent = int64(len(body.data)), int64(body.size)
+	}
+	c.metrics.RequestStarted(m.Method, m.Route)
+	start := time.Now()
+
+	var resp *http.Response
+	if c.endpoints != nil {
+		resp, err = c.sendFailover(ctx, method, path, body)
+	} else {
+		resp, err = c.sendTo(ctx, c.baseURL, method, path, body)
+	}
+	m.Duration = time.Since(start)
+	if err != nil {
+		release()
+		c.metrics.RequestFinished(m)
+	} else {
+		if c.limiters != nil {
+			resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+		}
+		m.StatusCode = resp.StatusCode
+		wire := &byteCounter{ReadCloser: resp.Body}
+		resp.Body = wire
+		decodeResponse(resp)
+		resp.Body = &metricsBody{ReadCloser: resp.Body, recorder: c.metrics, metrics: m, wire: wire}
+	}
+	if done != nil {
+		switch {
+		case err != nil && (ctx.Err() != nil || errors.Is(err, ErrNoEndpoints) || isPermanent(err)):
+			done(outcomeIgnored)
+		case err != nil || resp.StatusCode >= 500:
+			done(outcomeFailure)
+		default:
+			done(outcomeSuccess)
+		}
+	}
+	return resp, err
+}
+
+// sendTo sends a request to the given base URL. It is the bare HTTP exchange,
+// outside the client's limits, breakers and metrics.
+func (c *Client) sendTo(ctx context.Context, baseURL, method, path string, body *requestBody) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body.data)
+	}
+
+	url := baseURL + path
 	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
@@ -63,15 +323,30 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body interf
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
-	if c.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	if body != nil && body.encoding != "" {
+		req.Header.Set("Content-Encoding", body.encoding)
+	}
+	setTraceHeaders(ctx, req)
+	setIdempotencyKey(ctx, req)
+	setCacheValidators(ctx, req)
+	if c.credentials != nil {
+		token, err := c.credentials.Token(ctx)
+		if err != nil {
+			return nil, &permanentError{fmt.Errorf("failed to get credentials: %w", err)}
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	for key, values := range c.headers {
+		req.Header[key] = values
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
-
 	return resp, nil
 }
 
@@ -79,13 +354,13 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body interf
 func (c *Client) parseResponse(resp *http.Response, target interface{}) error {
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(c.limitBody(resp.Body))
 	if err != nil {
 		return fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return newAPIError(resp, body)
 	}
 
 	if target != nil {
@@ -97,6 +372,15 @@ func (c *Client) parseResponse(resp *http.Response, target interface{}) error {
 	return nil
 }
 
+// truncateBody returns the body as a string, cut down to maxErrorBodyBytes so a
+// large error page doesn't end up in logs whole
+func truncateBody(body []byte) string {
+	if len(body) <= maxErrorBodyBytes {
+		return string(body)
+	}
+	return fmt.Sprintf("%s…(truncated %d bytes)", body[:maxErrorBodyBytes], len(body)-maxErrorBodyBytes)
+}
+
 // HealthCheck checks if the Control Service is healthy
 func (c *Client) HealthCheck(ctx context.Context) error {
 	resp, err := c.doRequest(ctx, "GET", "/api/v1/health", nil)
@@ -0,0 +1,160 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings for request compression
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// acceptEncoding is sent with every request. Responses in these encodings are
+// decoded by the client itself, so it can measure the compressed size.
+const acceptEncoding = "zstd, gzip"
+
+// CompressionConfig enables compression of request bodies
+type CompressionConfig struct {
+	// Encoding is CompressionGzip or CompressionZstd (default gzip)
+	Encoding string
+	// MinBytes is the smallest body worth compressing (default 1024). Smaller
+	// ones are sent as they are.
+	MinBytes int
+}
+
+// requestBody is a marshaled request body, compressed if the client is
+// configured to
+type requestBody struct {
+	data []byte
+	// encoding is the Content-Encoding of data, or "" if it is plain JSON
+	encoding string
+	// size is the length of the JSON before compression
+	size int
+}
+
+// compressor compresses request bodies per a CompressionConfig
+type compressor struct {
+	config CompressionConfig
+	zstd   *zstd.Encoder
+}
+
+func newCompressor(config CompressionConfig) (*compressor, error) {
+	if config.Encoding == "" {
+		config.Encoding = CompressionGzip
+	}
+	if config.MinBytes <= 0 {
+		config.MinBytes = 1024
+	}
+
+	c := &compressor{config: config}
+	switch config.Encoding {
+	case CompressionGzip:
+	case CompressionZstd:
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		c.zstd = enc
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", config.Encoding)
+	}
+	return c, nil
+}
+
+// body wraps jsonData as a request body, compressing it if it is big enough
+func (c *compressor) body(jsonData []byte) (*requestBody, error) {
+	rb := &requestBody{data: jsonData, size: len(jsonData)}
+	if c == nil || len(jsonData) < c.config.MinBytes {
+		return rb, nil
+	}
+
+	switch c.config.Encoding {
+	case CompressionZstd:
+		rb.data = c.zstd.EncodeAll(jsonData, make([]byte, 0, len(jsonData)/4))
+	default:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(jsonData); err != nil {
+			return nil, fmt.Errorf("failed to compress request body: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress request body: %w", err)
+		}
+		rb.data = buf.Bytes()
+	}
+	rb.encoding = c.config.Encoding
+	return rb, nil
+}
+
+// byteCounter counts the bytes read through it
+type byteCounter struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *byteCounter) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// decodeResponse replaces a gzip or zstd encoded response body with the
+// decoded one
+func decodeResponse(resp *http.Response) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding != CompressionGzip && encoding != CompressionZstd {
+		return
+	}
+	resp.Body = &decodedBody{raw: resp.Body, encoding: encoding}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// decodedBody decompresses a response body. The decoder is set up on the
+// first read, so empty bodies, such as on a 204, don't fail.
+type decodedBody struct {
+	raw      io.ReadCloser
+	encoding string
+	r        io.Reader
+	zr       *zstd.Decoder
+	err      error
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) {
+	if d.r == nil && d.err == nil {
+		switch d.encoding {
+		case CompressionZstd:
+			d.zr, d.err = zstd.NewReader(d.raw, zstd.WithDecoderConcurrency(1))
+			d.r = d.zr
+		default:
+			d.r, d.err = gzip.NewReader(d.raw)
+		}
+		if d.err != nil {
+			d.err = fmt.Errorf("failed to decompress response: %w", d.err)
+		}
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.r.Read(p)
+}
+
+func (d *decodedBody) Close() error {
+	if d.zr != nil {
+		d.zr.Close()
+	}
+	return d.raw.Close()
+}
@@ -0,0 +1,116 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func decompress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var r io.Reader
+	switch encoding {
+	case CompressionZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	case CompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	default:
+		return data
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	if encoding == CompressionZstd {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer enc.Close()
+		return enc.EncodeAll(data, nil)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	for _, encoding := range []string{CompressionGzip, CompressionZstd} {
+		var gotEncoding, gotAccept string
+		srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+			gotEncoding, gotAccept = r.Header.Get("Content-Encoding"), r.Header.Get("Accept-Encoding")
+			raw, _ := io.ReadAll(r.Body)
+			var req CreateAccountRequest
+			if err := json.Unmarshal(decompress(t, gotEncoding, raw), &req); err != nil {
+				t.Errorf("expected a JSON body once decoded, got %v", err)
+			}
+
+			// Answer in the same encoding, echoing the name back
+			body, _ := json.Marshal(Account{ID: "acc", Name: req.Name})
+			w.Header().Set("Content-Encoding", encoding)
+			w.Write(compress(t, encoding, body))
+		})
+		c := NewClient(Config{BaseURL: srv.URL, Compression: &CompressionConfig{Encoding: encoding, MinBytes: 512}})
+
+		name := strings.Repeat("a", 1000)
+		account, err := c.CreateAccount(context.Background(), CreateAccountRequest{Name: name})
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		if gotEncoding != encoding || gotAccept != acceptEncoding {
+			t.Fatalf("%s: expected Content-Encoding %s and Accept-Encoding %q, got %q and %q", encoding, encoding, acceptEncoding, gotEncoding, gotAccept)
+		}
+		if account.Name != name {
+			t.Fatalf("%s: expected the decoded response, got a name of %d bytes", encoding, len(account.Name))
+		}
+
+		// Bodies under MinBytes aren't worth compressing
+		if _, err := c.CreateAccount(context.Background(), CreateAccountRequest{Name: "small"}); err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		if gotEncoding != "" {
+			t.Fatalf("%s: expected a small body to be sent as it is, got Content-Encoding %q", encoding, gotEncoding)
+		}
+	}
+}
+
+func TestCompressionInvalid(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusOK)
+	})
+	c := NewClient(Config{BaseURL: srv.URL, Compression: &CompressionConfig{Encoding: "brotli"}})
+
+	_, err := c.CreateAccount(context.Background(), CreateAccountRequest{Name: "a"})
+	if err == nil || !strings.Contains(err.Error(), `invalid compression configuration: unsupported compression "brotli"`) {
+		t.Fatalf("expected an invalid compression configuration error, got %v", err)
+	}
+	if srv.Calls() != 0 {
+		t.Fatalf("expected no request to be sent, got %d", srv.Calls())
+	}
+}
@@ -0,0 +1,487 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConfigSource names the layer a configuration value came from
+type ConfigSource string
+
+// Configuration layers, lowest priority first
+const (
+	ConfigSourceDefault ConfigSource = "default"
+	ConfigSourceLocal   ConfigSource = "local"
+	ConfigSourceAccount ConfigSource = "account"
+	ConfigSourceTenant  ConfigSource = "tenant"
+	ConfigSourceDataset ConfigSource = "dataset"
+)
+
+// DefaultConfigCacheDuration is how long a ConfigHelper keeps configuration
+// fetched from the Control Service
+const DefaultConfigCacheDuration = 5 * time.Minute
+
+// DefaultConfigErrorCacheDuration is how long a ConfigHelper keeps serving
+// fallback configuration after a failed fetch before trying again
+const DefaultConfigErrorCacheDuration = 15 * time.Second
+
+// configFetchTimeout bounds a fetch, which runs apart from the callers'
+// contexts
+const configFetchTimeout = 30 * time.Second
+
+// ConfigHelper merges Account, Tenant and Dataset configuration from the
+// Control Service over local and default configuration. Each layer overrides
+// the ones below it key by key, with nested maps merged rather than replaced.
+// Results are cached, and the helper is safe for concurrent use.
+type ConfigHelper struct {
+	client        *Client
+	localConfig   map[string]interface{}
+	defaultConfig map[string]interface{}
+
+	mu                 sync.Mutex
+	cacheDuration      time.Duration
+	errorCacheDuration time.Duration
+	cache              map[configKey]*configEntry
+	inflight           map[configKey]*configCall
+	// generation counts invalidations, so a fetch that started before one
+	// doesn't cache its result after it
+	generation uint64
+	logFunc    func(format string, args ...interface{})
+}
+
+type configKey struct {
+	accountID string
+	tenantID  string
+	datasetID string
+}
+
+type configEntry struct {
+	config *ResolvedConfig
+	// err is set when config is a fallback after a failed fetch
+	err       error
+	expiresAt time.Time
+}
+
+// configCall is a fetch in progress, which concurrent callers for the same
+// key wait for rather than fetching again
+type configCall struct {
+	done   chan struct{}
+	config *ResolvedConfig
+	err    error
+}
+
+// NewConfigHelper creates a ConfigHelper. localConfig and defaultConfig may be
+// nil; they are copied, so later changes to them have no effect.
+func NewConfigHelper(client *Client, localConfig, defaultConfig map[string]interface{}) *ConfigHelper {
+	return &ConfigHelper{
+		client:             client,
+		localConfig:        copyConfigMap(localConfig),
+		defaultConfig:      copyConfigMap(defaultConfig),
+		cacheDuration:      DefaultConfigCacheDuration,
+		errorCacheDuration: DefaultConfigErrorCacheDuration,
+		cache:              make(map[configKey]*configEntry),
+		inflight:           make(map[configKey]*configCall),
+	}
+}
+
+// SetLogFunc sets a custom log function, told when a fetch fails and fallback
+// configuration is used. If not set, logs are discarded.
+func (h *ConfigHelper) SetLogFunc(f func(format string, args ...interface{})) {
+	h.mu.Lock()
+	h.logFunc = f
+	h.mu.Unlock()
+}
+
+// SetCacheDuration changes how long fetched configuration is kept. Zero or
+// less disables caching.
+func (h *ConfigHelper) SetCacheDuration(d time.Duration) {
+	h.mu.Lock()
+	h.cacheDuration = d
+	h.mu.Unlock()
+}
+
+// SetErrorCacheDuration changes how long fallback configuration is served
+// after a failed fetch before the Control Service is asked again. Zero or less
+// retries on every call.
+func (h *ConfigHelper) SetErrorCacheDuration(d time.Duration) {
+	h.mu.Lock()
+	h.errorCacheDuration = d
+	h.mu.Unlock()
+}
+
+// InvalidateCache drops all cached configuration, so the next call fetches it
+// again. Fetches already in progress are not cached when they finish.
+func (h *ConfigHelper) InvalidateCache() {
+	h.mu.Lock()
+	h.generation++
+	h.cache = make(map[configKey]*configEntry)
+	h.inflight = make(map[configKey]*configCall)
+	h.mu.Unlock()
+}
+
+// GetTenantConfig returns a tenant's merged configuration. If the Control
+// Service can't be reached, it returns the last configuration fetched for the
+// tenant or, failing that, local over default configuration, along with the
+// error.
+func (h *ConfigHelper) GetTenantConfig(ctx context.Context, accountID, tenantID string) (map[string]interface{}, error) {
+	config, err := h.ResolveTenantConfig(ctx, accountID, tenantID)
+	return config.Map(), err
+}
+
+// GetDatasetConfig is GetTenantConfig with a dataset's configuration on top
+func (h *ConfigHelper) GetDatasetConfig(ctx context.Context, accountID, tenantID, datasetID string) (map[string]interface{}, error) {
+	config, err := h.ResolveDatasetConfig(ctx, accountID, tenantID, datasetID)
+	return config.Map(), err
+}
+
+// ResolveTenantConfig is GetTenantConfig, returning a ResolvedConfig that
+// also reports where each value came from
+func (h *ConfigHelper) ResolveTenantConfig(ctx context.Context, accountID, tenantID string) (*ResolvedConfig, error) {
+	return h.resolve(ctx, configKey{accountID: accountID, tenantID: tenantID})
+}
+
+// ResolveDatasetConfig is GetDatasetConfig, returning a ResolvedConfig
+func (h *ConfigHelper) ResolveDatasetConfig(ctx context.Context, accountID, tenantID, datasetID string) (*ResolvedConfig, error) {
+	return h.resolve(ctx, configKey{accountID: accountID, tenantID: tenantID, datasetID: datasetID})
+}
+
+// GetConfigString returns the tenant's value at path, a key or a dotted path
+// into nested maps such as "compression.codec", or def if it is not set or
+// not a string, number or bool
+func (h *ConfigHelper) GetConfigString(ctx context.Context, accountID, tenantID, path, def string) string {
+	config, _ := h.ResolveTenantConfig(ctx, accountID, tenantID)
+	return config.String(path, def)
+}
+
+// GetConfigInt returns the tenant's value at path as an int, or def if it is
+// not set or not a whole number
+func (h *ConfigHelper) GetConfigInt(ctx context.Context, accountID, tenantID, path string, def int) int {
+	config, _ := h.ResolveTenantConfig(ctx, accountID, tenantID)
+	return config.Int(path, def)
+}
+
+// GetConfigBool returns the tenant's value at path as a bool, or def if it is
+// not set or not a bool
+func (h *ConfigHelper) GetConfigBool(ctx context.Context, accountID, tenantID, path string, def bool) bool {
+	config, _ := h.ResolveTenantConfig(ctx, accountID, tenantID)
+	return config.Bool(path, def)
+}
+
+// resolve returns the cached configuration for key, fetching it if it is
+// missing or expired. Concurrent callers share one fetch, and after a failed
+// fetch the fallback is cached for the error cache duration, so an outage
+// doesn't cost every call a round of requests.
+func (h *ConfigHelper) resolve(ctx context.Context, key configKey) (*ResolvedConfig, error) {
+	h.mu.Lock()
+	entry := h.cache[key]
+	if entry != nil && time.Now().Before(entry.expiresAt) {
+		h.mu.Unlock()
+		return entry.config, entry.err
+	}
+	call := h.inflight[key]
+	if call == nil {
+		call = &configCall{done: make(chan struct{})}
+		h.inflight[key] = call
+		go h.runFetch(ctx, key, call, entry, h.generation)
+	}
+	h.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.config, call.err
+	case <-ctx.Done():
+		return h.fallback(entry), ctx.Err()
+	}
+}
+
+// runFetch fetches key for the callers waiting on call. The fetch is detached
+// from the context of the caller that started it, so that caller giving up
+// doesn't fail the others; ctx's values, such as its trace, are kept.
+func (h *ConfigHelper) runFetch(ctx context.Context, key configKey, call *configCall, entry *configEntry, generation uint64) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), configFetchTimeout)
+	defer cancel()
+
+	config, err := h.fetch(ctx, key)
+
+	h.mu.Lock()
+	now := time.Now()
+	if err != nil {
+		config = h.fallback(entry)
+	}
+	if generation == h.generation {
+		switch {
+		case err != nil:
+			if h.errorCacheDuration > 0 {
+				h.cache[key] = &configEntry{config: config, err: err, expiresAt: now.Add(h.errorCacheDuration)}
+			}
+		case h.cacheDuration > 0:
+			h.cache[key] = &configEntry{config: config, expiresAt: now.Add(h.cacheDuration)}
+		default:
+			delete(h.cache, key)
+		}
+	}
+	if h.inflight[key] == call {
+		delete(h.inflight, key)
+	}
+	logFunc := h.logFunc
+	h.mu.Unlock()
+
+	if err != nil && logFunc != nil {
+		logFunc("config helper: using fallback configuration for tenant %s: %v", key.tenantID, err)
+	}
+
+	call.config, call.err = config, err
+	close(call.done)
+}
+
+// fallback returns the configuration to use when a fetch fails: the last one
+// fetched, if there is one, else local over default configuration
+func (h *ConfigHelper) fallback(entry *configEntry) *ResolvedConfig {
+	if entry != nil {
+		return entry.config
+	}
+	return h.merge(nil, nil, nil)
+}
+
+// fetch gets the API layers for key and merges them
+func (h *ConfigHelper) fetch(ctx context.Context, key configKey) (*ResolvedConfig, error) {
+	account, err := h.client.GetAccount(ctx, key.accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account config: %w", err)
+	}
+	tenant, err := h.client.GetTenant(ctx, key.accountID, key.tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant config: %w", err)
+	}
+	var datasetConfig map[string]interface{}
+	if key.datasetID != "" {
+		dataset, err := h.client.GetDataset(ctx, key.tenantID, key.datasetID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dataset config: %w", err)
+		}
+		datasetConfig = dataset.Config
+	}
+	return h.merge(account.Config, tenant.Config, datasetConfig), nil
+}
+
+// merge layers the given API configuration over local and default
+// configuration
+func (h *ConfigHelper) merge(account, tenant, dataset map[string]interface{}) *ResolvedConfig {
+	rc := &ResolvedConfig{
+		values:  make(map[string]interface{}),
+		sources: make(map[string]ConfigSource),
+	}
+	rc.mergeLayer(rc.values, h.defaultConfig, ConfigSourceDefault, "")
+	rc.mergeLayer(rc.values, h.localConfig, ConfigSourceLocal, "")
+	rc.mergeLayer(rc.values, account, ConfigSourceAccount, "")
+	rc.mergeLayer(rc.values, tenant, ConfigSourceTenant, "")
+	rc.mergeLayer(rc.values, dataset, ConfigSourceDataset, "")
+	return rc
+}
+
+// ResolvedConfig is merged configuration that knows which layer supplied each
+// value. It must not be modified; Map returns a copy that can be.
+type ResolvedConfig struct {
+	values map[string]interface{}
+	// sources maps the dotted path of every key, nested ones included, to
+	// the highest layer that set it
+	sources map[string]ConfigSource
+}
+
+// mergeLayer merges src into dst, recording layer as the source of every path
+// it sets
+func (rc *ResolvedConfig) mergeLayer(dst, src map[string]interface{}, layer ConfigSource, prefix string) {
+	for k, v := range src {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		rc.sources[path] = layer
+
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			rc.mergeLayer(dstMap, srcMap, layer, path)
+			continue
+		}
+
+		// The value is replaced outright, so nothing beneath it survives
+		for p := range rc.sources {
+			if strings.HasPrefix(p, path+".") {
+				delete(rc.sources, p)
+			}
+		}
+		dst[k] = copyConfigValue(v)
+		if srcIsMap {
+			rc.markSources(srcMap, layer, path)
+		}
+	}
+}
+
+func (rc *ResolvedConfig) markSources(m map[string]interface{}, layer ConfigSource, prefix string) {
+	for k, v := range m {
+		path := prefix + "." + k
+		rc.sources[path] = layer
+		if sub, ok := v.(map[string]interface{}); ok {
+			rc.markSources(sub, layer, path)
+		}
+	}
+}
+
+// Map returns a copy of the merged configuration
+func (rc *ResolvedConfig) Map() map[string]interface{} {
+	if rc == nil {
+		return nil
+	}
+	return copyConfigMap(rc.values)
+}
+
+// Get returns the value at path, a key or a dotted path into nested maps.
+// Maps it returns must not be modified.
+func (rc *ResolvedConfig) Get(path string) (interface{}, bool) {
+	if rc == nil {
+		return nil, false
+	}
+	var cur interface{} = rc.values
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// Source returns the layer that supplied the value at path, or "" if it is
+// not set. For a nested map it is the highest layer that set any key in it.
+func (rc *ResolvedConfig) Source(path string) ConfigSource {
+	if rc == nil {
+		return ""
+	}
+	return rc.sources[path]
+}
+
+// Sources returns the layer that supplied each key, by dotted path
+func (rc *ResolvedConfig) Sources() map[string]ConfigSource {
+	if rc == nil {
+		return nil
+	}
+	out := make(map[string]ConfigSource, len(rc.sources))
+	for k, v := range rc.sources {
+		out[k] = v
+	}
+	return out
+}
+
+// String returns the value at path as a string, or def if it is not set or
+// not a string, number or bool
+func (rc *ResolvedConfig) String(path, def string) string {
+	v, ok := rc.Get(path)
+	if !ok {
+		return def
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool, int, int32, int64, float32, float64, json.Number:
+		return fmt.Sprint(v)
+	default:
+		return def
+	}
+}
+
+// Int returns the value at path as an int, or def if it is not set or not a
+// whole number. Numeric strings are accepted.
+func (rc *ResolvedConfig) Int(path string, def int) int {
+	v, ok := rc.Get(path)
+	if !ok {
+		return def
+	}
+	switch v := v.(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float32:
+		return floatToInt(float64(v), def)
+	case float64:
+		// JSON numbers decode as float64
+		return floatToInt(v, def)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return int(n)
+		}
+	case string:
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func floatToInt(f float64, def int) int {
+	if f != math.Trunc(f) || f > math.MaxInt || f < math.MinInt {
+		return def
+	}
+	return int(f)
+}
+
+// Bool returns the value at path as a bool, or def if it is not set or not a
+// bool. Strings such as "true" and "0" are accepted.
+func (rc *ResolvedConfig) Bool(path string, def bool) bool {
+	v, ok := rc.Get(path)
+	if !ok {
+		return def
+	}
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// copyConfigMap deep-copies a configuration map, so merging never modifies
+// the caller's or a cached map
+func copyConfigMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = copyConfigValue(v)
+	}
+	return out
+}
+
+func copyConfigValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return copyConfigMap(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = copyConfigValue(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
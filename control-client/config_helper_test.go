@@ -0,0 +1,164 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// configHandler serves an account whose config holds "version", and a tenant
+// with a nested setting
+func configHandler(version *int32) func(w http.ResponseWriter, r *http.Request, call int) {
+	return func(w http.ResponseWriter, r *http.Request, call int) {
+		if strings.Contains(r.URL.Path, "/tenants/") {
+			w.Write([]byte(`{"id": "ten", "config": {"compression": {"codec": "zstd"}}}`))
+			return
+		}
+		fmt.Fprintf(w, `{"id": "acc", "config": {"version": %d, "compression": {"level": 3}}}`, atomic.LoadInt32(version))
+	}
+}
+
+func TestConfigHelperMerge(t *testing.T) {
+	version := int32(1)
+	srv := newTestServer(t, configHandler(&version))
+	h := NewConfigHelper(NewClient(Config{BaseURL: srv.URL}),
+		map[string]interface{}{"compression": map[string]interface{}{"codec": "gzip"}, "batch": 100},
+		map[string]interface{}{"batch": 10, "flush": "1s"})
+
+	config, err := h.ResolveTenantConfig(context.Background(), "acc", "ten")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]ConfigSource{
+		"compression.codec": ConfigSourceTenant,
+		"compression.level": ConfigSourceAccount,
+		"batch":             ConfigSourceLocal,
+		"flush":             ConfigSourceDefault,
+	} {
+		if got := config.Source(path); got != want {
+			t.Fatalf("expected %s from %s, got %s", path, want, got)
+		}
+	}
+	if codec := config.String("compression.codec", ""); codec != "zstd" {
+		t.Fatalf("expected the tenant's codec, got %q", codec)
+	}
+	if batch := h.GetConfigInt(context.Background(), "acc", "ten", "batch", 0); batch != 100 {
+		t.Fatalf("expected the local batch size, got %d", batch)
+	}
+	if srv.Calls() != 2 {
+		t.Fatalf("expected the second call to be served from the cache, got %d requests", srv.Calls())
+	}
+}
+
+func TestConfigHelperOutage(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	h := NewConfigHelper(NewClient(Config{BaseURL: srv.URL, Retry: RetryPolicy{MaxAttempts: 1}}),
+		map[string]interface{}{"batch": 100}, nil)
+	var logged int32
+	h.SetLogFunc(func(format string, args ...interface{}) { atomic.AddInt32(&logged, 1) })
+
+	if _, err := h.GetTenantConfig(context.Background(), "acc", "ten"); err == nil {
+		t.Fatal("expected the fetch error")
+	}
+	for i := 0; i < 10; i++ {
+		if batch := h.GetConfigInt(context.Background(), "acc", "ten", "batch", 0); batch != 100 {
+			t.Fatalf("expected the local fallback, got %d", batch)
+		}
+	}
+	if srv.Calls() != 1 || atomic.LoadInt32(&logged) != 1 {
+		t.Fatalf("expected the fallback to be cached after one failed fetch, got %d requests and %d logs", srv.Calls(), logged)
+	}
+}
+
+func TestConfigHelperLeaderCancelled(t *testing.T) {
+	release := make(chan struct{})
+	version := int32(1)
+	handler := configHandler(&version)
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		<-release
+		handler(w, r, call)
+	})
+	h := NewConfigHelper(NewClient(Config{BaseURL: srv.URL}), nil, nil)
+
+	// The first caller starts the fetch and gives up on it
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := h.ResolveTenantConfig(leaderCtx, "acc", "ten")
+		leaderErr <- err
+	}()
+	for srv.Calls() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	waiterDone := make(chan error, 1)
+	var waiterConfig *ResolvedConfig
+	go func() {
+		var err error
+		waiterConfig, err = h.ResolveTenantConfig(context.Background(), "acc", "ten")
+		waiterDone <- err
+	}()
+
+	cancel()
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the leader to get context.Canceled, got %v", err)
+	}
+
+	close(release)
+	if err := <-waiterDone; err != nil {
+		t.Fatalf("expected the waiter to get the fetched configuration, got %v", err)
+	}
+	if v := waiterConfig.Int("version", 0); v != 1 {
+		t.Fatalf("expected version 1, got %d", v)
+	}
+	if srv.Calls() != 2 {
+		t.Fatalf("expected one shared fetch, got %d requests", srv.Calls())
+	}
+}
+
+func TestConfigHelperInvalidateDuringFetch(t *testing.T) {
+	release := make(chan struct{})
+	version := int32(1)
+	handler := configHandler(&version)
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		if call == 1 {
+			<-release
+		}
+		handler(w, r, call)
+	})
+	h := NewConfigHelper(NewClient(Config{BaseURL: srv.URL}), nil, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ResolveTenantConfig(context.Background(), "acc", "ten")
+	}()
+	for srv.Calls() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The configuration changes and the cache is invalidated while the
+	// first fetch is still out
+	atomic.StoreInt32(&version, 2)
+	h.InvalidateCache()
+	close(release)
+	<-done
+
+	config, err := h.ResolveTenantConfig(context.Background(), "acc", "ten")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := config.Int("version", 0); v != 2 {
+		t.Fatalf("expected the stale fetch not to be cached, got version %d", v)
+	}
+}
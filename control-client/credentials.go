@@ -0,0 +1,283 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+// CredentialsProvider supplies the bearer token sent with each request. It is
+// called for every request, so implementations should cache.
+type CredentialsProvider interface {
+	// Token returns the token to send. An empty token sends no Authorization
+	// header.
+	Token(ctx context.Context) (string, error)
+	// Refresh is called when the Control Service rejected token with a 401.
+	// Implementations should obtain new credentials, unless they have already
+	// replaced the rejected token.
+	Refresh(ctx context.Context, rejected string) error
+}
+
+// StaticCredentials returns a provider that always sends key. It is what
+// Config.APIKey uses.
+func StaticCredentials(key string) CredentialsProvider {
+	return staticCredentials(key)
+}
+
+type staticCredentials string
+
+func (s staticCredentials) Token(context.Context) (string, error) {
+	return string(s), nil
+}
+
+func (s staticCredentials) Refresh(context.Context, string) error {
+	return nil
+}
+
+// EnvCredentials returns a provider that reads the key from the environment
+// variable name on every request
+func EnvCredentials(name string) CredentialsProvider {
+	return envCredentials(name)
+}
+
+type envCredentials string
+
+func (e envCredentials) Token(context.Context) (string, error) {
+	key := os.Getenv(string(e))
+	if key == "" {
+		return "", fmt.Errorf("environment variable %s is not set", string(e))
+	}
+	return key, nil
+}
+
+func (e envCredentials) Refresh(context.Context, string) error {
+	return nil
+}
+
+// FileCredentials returns a provider that reads the key from a file, such as
+// a mounted secret. The file is checked for changes every reloadInterval
+// (default 30s) and re-read at once when the server rejects the key, so a
+// rotated key is picked up without recreating the Client.
+func FileCredentials(path string, reloadInterval time.Duration) CredentialsProvider {
+	if reloadInterval <= 0 {
+		reloadInterval = 30 * time.Second
+	}
+	return &fileCredentials{path: path, reloadInterval: reloadInterval}
+}
+
+type fileCredentials struct {
+	path           string
+	reloadInterval time.Duration
+
+	mu        sync.Mutex
+	key       string
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func (f *fileCredentials) Token(context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.key != "" && time.Since(f.lastCheck) < f.reloadInterval {
+		return f.key, nil
+	}
+	if err := f.load(false); err != nil && f.key == "" {
+		return "", err
+	}
+	// A failed reload keeps the previous key, as the file may be caught
+	// halfway through being replaced
+	return f.key, nil
+}
+
+func (f *fileCredentials) Refresh(_ context.Context, rejected string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.key != rejected {
+		return nil
+	}
+	return f.load(true)
+}
+
+// load re-reads the key if the file changed since it was last read, or
+// always when force is set. f.mu must be held.
+func (f *fileCredentials) load(force bool) error {
+	f.lastCheck = time.Now()
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	if !force && f.key != "" && fi.ModTime().Equal(f.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return fmt.Errorf("credentials file %s is empty", f.path)
+	}
+	f.key, f.modTime = key, fi.ModTime()
+	return nil
+}
+
+// OAuth2Config configures the OAuth2 client-credentials flow
+type OAuth2Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// RefreshBefore is how long before expiry a token is replaced, so requests
+	// don't race its expiry (default 1 minute)
+	RefreshBefore time.Duration
+	// HTTPClient is used to call TokenURL (default: a client with a 30s
+	// timeout)
+	HTTPClient *http.Client
+}
+
+// OAuth2Credentials returns a provider that obtains tokens with the OAuth2
+// client-credentials grant. Tokens are cached and replaced shortly before they
+// expire.
+func OAuth2Credentials(config OAuth2Config) CredentialsProvider {
+	if config.RefreshBefore <= 0 {
+		config.RefreshBefore = time.Minute
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &oauth2Credentials{config: config}
+}
+
+type oauth2Credentials struct {
+	config OAuth2Config
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// tokenResponse is the token endpoint's answer, per RFC 6749 section 5
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (o *oauth2Credentials) Token(ctx context.Context) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.token != "" && (o.expiry.IsZero() || time.Until(o.expiry) > o.config.RefreshBefore) {
+		return o.token, nil
+	}
+	if err := o.fetch(ctx); err != nil {
+		return "", err
+	}
+	return o.token, nil
+}
+
+func (o *oauth2Credentials) Refresh(ctx context.Context, rejected string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.token != rejected {
+		// Another request got a new token already
+		return nil
+	}
+	return o.fetch(ctx)
+}
+
+// fetch gets a new token from the token endpoint. o.mu must be held.
+func (o *oauth2Credentials) fetch(ctx context.Context) error {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(o.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(o.config.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(o.config.ClientID), url.QueryEscape(o.config.ClientSecret))
+
+	resp, err := o.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	var tr tokenResponse
+	if err := sonic.Unmarshal(body, &tr); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, truncateBody(body))
+		}
+		return fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.AccessToken == "" {
+		msg := tr.Error
+		if tr.ErrorDescription != "" {
+			msg += ": " + tr.ErrorDescription
+		}
+		if msg == "" {
+			msg = "no access token in response"
+		}
+		return fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, msg)
+	}
+
+	o.token = tr.AccessToken
+	o.expiry = time.Time{}
+	if tr.ExpiresIn > 0 {
+		o.expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return nil
+}
+
+// sendAuthenticated sends a request, and if the server rejects the
+// credentials, refreshes them and tries once more
+func (c *Client) sendAuthenticated(ctx context.Context, method, path string, body *requestBody) (*http.Response, error) {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || c.credentials == nil {
+		return resp, err
+	}
+
+	var rejected string
+	if resp.Request != nil {
+		rejected = strings.TrimPrefix(resp.Request.Header.Get("Authorization"), "Bearer ")
+	}
+	if err := c.credentials.Refresh(ctx, rejected); err != nil {
+		// Hand back the 401, which says more than the refresh failure
+		return resp, nil
+	}
+	token, err := c.credentials.Token(ctx)
+	if err != nil || token == rejected {
+		// Trying again would be rejected the same way
+		return resp, nil
+	}
+
+	discardResponse(resp)
+	return c.send(ctx, method, path, body)
+}
@@ -0,0 +1,146 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newTokenServer starts an OAuth2 token endpoint that issues tok-1, tok-2 and
+// so on, each valid for expiresIn seconds
+func newTokenServer(t *testing.T, expiresIn int) *testServer {
+	return newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		id, secret, _ := r.BasicAuth()
+		if r.Method != http.MethodPost || id != "client" || secret != "s3cret" {
+			t.Errorf("expected a POST with the client's credentials, got %s as %q", r.Method, id)
+		}
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read write" {
+			t.Errorf("expected the client-credentials grant and scopes, got %v", r.Form)
+		}
+		fmt.Fprintf(w, `{"access_token": "tok-%d", "token_type": "Bearer", "expires_in": %d}`, call, expiresIn)
+	})
+}
+
+// newBearerServer starts a server that accepts only the given token, and
+// records the last Authorization header it got
+func newBearerServer(t *testing.T, accept string, got *string) *testServer {
+	return newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		*got = r.Header.Get("Authorization")
+		if *got != "Bearer "+accept {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func oauth2Client(baseURL, tokenURL string) *Client {
+	return NewClient(Config{
+		BaseURL: baseURL,
+		Retry:   RetryPolicy{MaxAttempts: 1},
+		Credentials: OAuth2Credentials(OAuth2Config{
+			TokenURL:     tokenURL,
+			ClientID:     "client",
+			ClientSecret: "s3cret",
+			Scopes:       []string{"read", "write"},
+		}),
+	})
+}
+
+func TestOAuth2TokenCached(t *testing.T) {
+	tokens := newTokenServer(t, 3600)
+	var auth string
+	srv := newBearerServer(t, "tok-1", &auth)
+	c := oauth2Client(srv.URL, tokens.URL)
+
+	for i := 0; i < 3; i++ {
+		if err := c.HealthCheck(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tokens.Calls() != 1 {
+		t.Fatalf("expected the token to be fetched once, got %d fetches", tokens.Calls())
+	}
+}
+
+func TestOAuth2RefreshBeforeExpiry(t *testing.T) {
+	// Tokens expire within the default RefreshBefore of a minute, so each is
+	// replaced before it is used again
+	tokens := newTokenServer(t, 30)
+	var auth string
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		auth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	})
+	c := oauth2Client(srv.URL, tokens.URL)
+
+	for i := 1; i <= 2; i++ {
+		if err := c.HealthCheck(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("Bearer tok-%d", i); auth != want {
+			t.Fatalf("expected %q, got %q", want, auth)
+		}
+	}
+	if tokens.Calls() != 2 {
+		t.Fatalf("expected a fetch per request, got %d fetches", tokens.Calls())
+	}
+}
+
+func TestOAuth2RefreshAfterUnauthorized(t *testing.T) {
+	tokens := newTokenServer(t, 3600)
+	var auth string
+	// The server has revoked tok-1 although it hasn't expired
+	srv := newBearerServer(t, "tok-2", &auth)
+	c := oauth2Client(srv.URL, tokens.URL)
+
+	if err := c.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("expected the request to succeed with a refreshed token, got %v", err)
+	}
+	if srv.Calls() != 2 || tokens.Calls() != 2 || auth != "Bearer tok-2" {
+		t.Fatalf("expected one retry with tok-2, got %d requests, %d fetches and %q", srv.Calls(), tokens.Calls(), auth)
+	}
+
+	// The refreshed token is kept
+	if err := c.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if tokens.Calls() != 2 {
+		t.Fatalf("expected the refreshed token to be cached, got %d fetches", tokens.Calls())
+	}
+}
+
+func TestOAuth2TokenEndpointFails(t *testing.T) {
+	cases := map[string]struct {
+		status int
+		body   string
+		want   string
+	}{
+		"oauth2 error": {http.StatusBadRequest, `{"error": "invalid_client", "error_description": "bad secret"}`, "status 400: invalid_client: bad secret"},
+		"not json":     {http.StatusBadGateway, `upstream down`, "status 502: upstream down"},
+		"no token":     {http.StatusOK, `{"token_type": "Bearer"}`, "no access token in response"},
+	}
+	for name, tc := range cases {
+		tokens := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+			w.WriteHeader(tc.status)
+			w.Write([]byte(tc.body))
+		})
+		srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+			w.WriteHeader(http.StatusOK)
+		})
+		c := oauth2Client(srv.URL, tokens.URL)
+
+		err := c.HealthCheck(context.Background())
+		if err == nil || !strings.Contains(err.Error(), "failed to get credentials") || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected a credentials error with %q, got %v", name, tc.want, err)
+		}
+		if srv.Calls() != 0 {
+			t.Fatalf("%s: expected no request without a token, got %d", name, srv.Calls())
+		}
+	}
+}
@@ -10,27 +10,21 @@ import (
 
 // ListDatasets retrieves all datasets for a tenant
 func (c *Client) ListDatasets(ctx context.Context, tenantID string, limit int) ([]Dataset, error) {
+	return collectPage(c.ListDatasetsPage(ctx, tenantID, PageOptions{Limit: limit}))
+}
+
+// ListDatasetsPage retrieves one page of a tenant's datasets
+func (c *Client) ListDatasetsPage(ctx context.Context, tenantID string, opts PageOptions) (*Page[Dataset], error) {
 	// Need to determine the account ID from tenant first, or change the API path
 	// For now, using the direct tenant path which should work
-	path := fmt.Sprintf("/api/v1/tenants/%s/datasets", tenantID)
-	if limit > 0 {
-		path = fmt.Sprintf("%s?limit=%d", path, limit)
-	}
-
-	resp, err := c.doRequest(ctx, "GET", path, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	var result struct {
-		Items []Dataset `json:"items"`
-		Total int       `json:"total"`
-	}
-	if err := c.parseResponse(resp, &result); err != nil {
-		return nil, err
-	}
+	return getPage[Dataset](ctx, c, fmt.Sprintf("/api/v1/tenants/%s/datasets", tenantID), nil, opts)
+}
 
-	return result.Items, nil
+// IterateDatasets walks all of a tenant's datasets, opts.Limit at a time
+func (c *Client) IterateDatasets(ctx context.Context, tenantID string, opts PageOptions) *Iterator[Dataset] {
+	return newIterator(ctx, opts, func(ctx context.Context, opts PageOptions) (*Page[Dataset], error) {
+		return c.ListDatasetsPage(ctx, tenantID, opts)
+	})
 }
 
 // GetDataset retrieves a dataset by ID
@@ -0,0 +1,135 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import "strings"
+
+// Endpoint groups, used to scope circuit breakers and other per-endpoint
+// settings to a family of related API calls
+const (
+	EndpointGroupLocks     = "locks"
+	EndpointGroupMetadata  = "metadata"
+	EndpointGroupJobs      = "jobs"
+	EndpointGroupCache     = "cache"
+	EndpointGroupAccounts  = "accounts"
+	EndpointGroupChanges   = "changes"
+	EndpointGroupHealth    = "health"
+	EndpointGroupInstances = "instances"
+	EndpointGroupOther     = "other"
+)
+
+// endpointGroup maps an API path onto its endpoint group
+func endpointGroup(path string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	p := strings.TrimPrefix(path, "/api/v1/")
+
+	switch {
+	case strings.HasPrefix(p, "piper/locks/"), p == "piper/locks",
+		strings.HasPrefix(p, "packer/locks/"), p == "packer/locks":
+		return EndpointGroupLocks
+	case strings.HasPrefix(p, "packer/metadata"), strings.HasPrefix(p, "packer/field-tracking"):
+		return EndpointGroupMetadata
+	case strings.HasPrefix(p, "piper/jobs"):
+		return EndpointGroupJobs
+	case strings.HasPrefix(p, "piper/cache"):
+		return EndpointGroupCache
+	case strings.HasPrefix(p, "accounts"), strings.HasPrefix(p, "tenants"):
+		return EndpointGroupAccounts
+	case strings.HasPrefix(p, "changes"):
+		return EndpointGroupChanges
+	case strings.HasPrefix(p, "health"):
+		return EndpointGroupHealth
+	case strings.HasPrefix(p, "instances"):
+		return EndpointGroupInstances
+	}
+	return EndpointGroupOther
+}
+
+// routes are the templates of the API paths the client calls, used to label
+// metrics without one series per tenant or key. Where two templates could
+// match the same path, the one with literal segments comes first.
+var routes = []string{
+	"/api/v1/health",
+	"/api/v1/health/reports",
+	"/api/v1/accounts",
+	"/api/v1/accounts/{account}",
+	"/api/v1/accounts/{account}/tenants",
+	"/api/v1/accounts/{account}/tenants/{tenant}",
+	"/api/v1/tenants/{tenant}/datasets",
+	"/api/v1/tenants/{tenant}/datasets/{dataset}",
+	"/api/v1/changes",
+	"/api/v1/instances",
+	"/api/v1/instances/{instance}",
+	"/api/v1/instances/{instance}/heartbeat",
+
+	"/api/v1/packer/locks/tenants",
+	"/api/v1/packer/locks/tenants/cleanup/expired",
+	"/api/v1/packer/locks/tenants/cleanup/all",
+	"/api/v1/packer/locks/tenants/cleanup/stale",
+	"/api/v1/packer/locks/tenants/{tenant}",
+	"/api/v1/packer/locks/tenants/{tenant}/heartbeat",
+	"/api/v1/packer/metadata/files",
+	"/api/v1/packer/metadata/files/cleanup/orphaned",
+	"/api/v1/packer/metadata/files/cleanup/expired",
+	"/api/v1/packer/metadata/files/{tenant}/{dataset}",
+	"/api/v1/packer/metadata/files/{tenant}/{dataset}/all",
+	"/api/v1/packer/metadata/generation/status",
+	"/api/v1/packer/metadata/generation/status/{tenant}/{dataset}/{partition}",
+	"/api/v1/packer/metadata/summary/{tenant}/{dataset}/{partition}",
+	"/api/v1/packer/field-tracking/batch",
+	"/api/v1/packer/field-tracking/{dataset}/cleanup",
+	"/api/v1/packer/field-tracking/{tenant}/{dataset}",
+
+	"/api/v1/piper/locks/files",
+	"/api/v1/piper/locks/files/cleanup/expired",
+	"/api/v1/piper/locks/files/cleanup/stale",
+	"/api/v1/piper/locks/files/{tenant}/{dataset}/{key}",
+	"/api/v1/piper/jobs",
+	"/api/v1/piper/jobs/cleanup/old",
+	"/api/v1/piper/jobs/tenant/{tenant}",
+	"/api/v1/piper/jobs/{job}",
+	"/api/v1/piper/jobs/{job}/status",
+	"/api/v1/piper/cache/pipelines",
+	"/api/v1/piper/cache/pipelines/cleanup/expired",
+	"/api/v1/piper/cache/pipelines/{tenant}/{dataset}",
+	"/api/v1/piper/cache/tenants",
+	"/api/v1/piper/cache/tenants/cleanup/expired",
+}
+
+// routeSegments is routes split into path segments
+var routeSegments = func() [][]string {
+	segs := make([][]string, len(routes))
+	for i, r := range routes {
+		segs[i] = strings.Split(strings.Trim(r, "/"), "/")
+	}
+	return segs
+}()
+
+// routeTemplate returns the template in routes that path matches, or
+// EndpointGroupOther if there is none
+func routeTemplate(path string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i, tmpl := range routeSegments {
+		if len(tmpl) != len(segs) {
+			continue
+		}
+		match := true
+		for j, s := range tmpl {
+			if !strings.HasPrefix(s, "{") && s != segs[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return routes[i]
+		}
+	}
+	return EndpointGroupOther
+}
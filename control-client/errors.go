@@ -0,0 +1,147 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bytedance/sonic"
+)
+
+// Sentinel errors for use with errors.Is. An *APIError matches the sentinel
+// for its status code, so callers don't need to inspect codes themselves:
+//
+//	if errors.Is(err, controlclient.ErrNotFound) { ... }
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrConflict     = errors.New("conflict")
+	ErrLockHeld     = errors.New("lock held by another owner")
+	ErrRateLimited  = errors.New("rate limited")
+)
+
+// ErrCircuitOpen is returned without contacting the Control Service while the
+// circuit breaker for the endpoint group is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// APIError is returned when the Control Service answers with an error status.
+// Use errors.As to get at the details.
+type APIError struct {
+	StatusCode int
+	// Code is the server's machine-readable error code, if it sent one
+	Code string
+	// Message is the server's error message, or the start of the body if it
+	// didn't send one
+	Message string
+	// RequestID is the server's request ID, or the X-Request-ID the client sent
+	// if the server gave none, for finding the call in its logs
+	RequestID string
+	Method    string
+	Path      string
+	// Body is the raw response body
+	Body []byte
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "API error (status %d)", e.StatusCode)
+	if e.Method != "" {
+		fmt.Fprintf(&b, " %s %s", e.Method, e.Path)
+	}
+	if e.Code != "" {
+		fmt.Fprintf(&b, " [%s]", e.Code)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&b, ": %s", e.Message)
+	}
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, " (request_id=%s)", e.RequestID)
+	}
+	return b.String()
+}
+
+// Is matches the sentinel errors by status code. ErrLockHeld matches 423, and
+// 409 responses whose error code mentions a lock. ErrIdempotencyKeyReused
+// matches 409 and 422 responses whose error code or message mentions the
+// idempotency key.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrLockHeld:
+		return e.StatusCode == http.StatusLocked ||
+			(e.StatusCode == http.StatusConflict && strings.Contains(strings.ToLower(e.Code), "lock"))
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrIdempotencyKeyReused:
+		return (e.StatusCode == http.StatusUnprocessableEntity || e.StatusCode == http.StatusConflict) &&
+			(strings.Contains(strings.ToLower(e.Code), "idempotency") ||
+				strings.Contains(strings.ToLower(e.Message), "idempotency"))
+	}
+	return false
+}
+
+// errorBody covers the error shapes the Control Service sends, either
+// {"error": "msg", "code": "..."} or {"error": {"code": "...", "message": "..."}}
+type errorBody struct {
+	Error     interface{} `json:"error"`
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"request_id"`
+}
+
+// newAPIError builds an APIError from an error response and its body
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get("X-Request-ID"),
+		Body:       body,
+	}
+	if resp.Request != nil {
+		apiErr.Method = resp.Request.Method
+		apiErr.Path = resp.Request.URL.Path
+	}
+
+	var eb errorBody
+	if err := sonic.Unmarshal(body, &eb); err == nil {
+		apiErr.Code = eb.Code
+		apiErr.Message = eb.Message
+		switch v := eb.Error.(type) {
+		case string:
+			if apiErr.Message == "" {
+				apiErr.Message = v
+			}
+		case map[string]interface{}:
+			if s, ok := v["code"].(string); ok && apiErr.Code == "" {
+				apiErr.Code = s
+			}
+			if s, ok := v["message"].(string); ok && apiErr.Message == "" {
+				apiErr.Message = s
+			}
+		}
+		if apiErr.RequestID == "" {
+			apiErr.RequestID = eb.RequestID
+		}
+	}
+	if apiErr.RequestID == "" && resp.Request != nil {
+		// The server didn't echo an ID, so give the one we sent, which it
+		// logs with the request
+		apiErr.RequestID = resp.Request.Header.Get("X-Request-ID")
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = truncateBody(body)
+	}
+
+	return apiErr
+}
@@ -0,0 +1,461 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoEndpoints is returned when failover is configured but no Control
+// Service endpoint is known, for example because the SRV lookup failed
+var ErrNoEndpoints = errors.New("no control service endpoints")
+
+// lookupSRV resolves FailoverConfig.SRV; tests replace it
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// FailoverConfig spreads requests over several Control Service replicas.
+// Requests go to the healthy endpoint with the lowest latency. An endpoint
+// that refuses connections or answers 5xx is taken out of rotation until a
+// background health probe succeeds. Call Client.Close to stop the probes.
+type FailoverConfig struct {
+	// BaseURLs are the replicas' base URLs. Config.BaseURL, if set, is used
+	// as the first of them.
+	BaseURLs []string
+	// SRV is a DNS SRV name, such as _control._tcp.bytefreezer.internal,
+	// whose targets are added to BaseURLs. It is resolved in the background,
+	// and again every ResolveInterval (default 1 minute); after a failed
+	// lookup it is retried sooner, backing off from one second. Until the
+	// first lookup is done, requests wait for it if there are no BaseURLs.
+	SRV             string
+	ResolveInterval time.Duration
+	// SRVScheme is the URL scheme for SRV targets (default "http")
+	SRVScheme string
+	// ProbeInterval is how often every endpoint's health endpoint is probed
+	// (default 10s). Probes bypass the client's limits, circuit breakers,
+	// cache and metrics.
+	ProbeInterval time.Duration
+}
+
+func (f FailoverConfig) withDefaults() FailoverConfig {
+	if f.ResolveInterval <= 0 {
+		f.ResolveInterval = time.Minute
+	}
+	if f.SRVScheme == "" {
+		f.SRVScheme = "http"
+	}
+	if f.ProbeInterval <= 0 {
+		f.ProbeInterval = 10 * time.Second
+	}
+	return f
+}
+
+// EndpointStatus describes one Control Service endpoint, for diagnostics
+type EndpointStatus struct {
+	URL     string
+	Healthy bool
+	// Active is set on the endpoint requests currently go to
+	Active bool
+	// Latency is a moving average of response times
+	Latency time.Duration
+	// LastError is the failure that took the endpoint out of rotation
+	LastError string
+	DownSince time.Time
+}
+
+// endpoint is the state of one replica
+type endpoint struct {
+	url       string
+	healthy   bool
+	latency   time.Duration
+	lastErr   error
+	downSince time.Time
+}
+
+// endpointPool tracks the replicas and their health
+type endpointPool struct {
+	config    FailoverConfig
+	static    []string
+	lookupSRV func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+
+	mu         sync.Mutex
+	endpoints  []*endpoint
+	resolveErr error
+
+	// resolved is closed once the first SRV lookup is done
+	resolved chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newEndpointPool(baseURL string, config FailoverConfig) *endpointPool {
+	config = config.withDefaults()
+	p := &endpointPool{config: config, lookupSRV: lookupSRV, resolved: make(chan struct{}), stop: make(chan struct{})}
+	if baseURL != "" {
+		p.static = append(p.static, strings.TrimRight(baseURL, "/"))
+	}
+	for _, u := range config.BaseURLs {
+		p.static = append(p.static, strings.TrimRight(u, "/"))
+	}
+	p.setURLs(p.static)
+	if config.SRV == "" {
+		close(p.resolved)
+	}
+	return p
+}
+
+// setURLs replaces the endpoint list, keeping the state of endpoints that
+// are still in it
+func (p *endpointPool) setURLs(urls []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old := make(map[string]*endpoint, len(p.endpoints))
+	for _, e := range p.endpoints {
+		old[e.url] = e
+	}
+	seen := make(map[string]bool, len(urls))
+	endpoints := make([]*endpoint, 0, len(urls))
+	for _, u := range urls {
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		if e := old[u]; e != nil {
+			endpoints = append(endpoints, e)
+		} else {
+			endpoints = append(endpoints, &endpoint{url: u, healthy: true})
+		}
+	}
+	p.endpoints = endpoints
+}
+
+// resolve looks up the SRV name and adds its targets to the static URLs. On
+// failure the previous list is kept.
+func (p *endpointPool) resolve(ctx context.Context) error {
+	_, addrs, err := p.lookupSRV(ctx, "", "", p.config.SRV)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("no SRV records for %s", p.config.SRV)
+	}
+	p.mu.Lock()
+	p.resolveErr = err
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	urls := append([]string(nil), p.static...)
+	for _, a := range addrs {
+		host := strings.TrimSuffix(a.Target, ".")
+		urls = append(urls, p.config.SRVScheme+"://"+net.JoinHostPort(host, strconv.Itoa(int(a.Port))))
+	}
+	p.setURLs(urls)
+	return nil
+}
+
+// waitResolved waits for the first SRV lookup if there is no endpoint to use
+// until it is done
+func (p *endpointPool) waitResolved(ctx context.Context) error {
+	p.mu.Lock()
+	empty := len(p.endpoints) == 0
+	p.mu.Unlock()
+	if !empty {
+		return nil
+	}
+
+	select {
+	case <-p.resolved:
+		return nil
+	case <-p.stop:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pick returns the endpoint for the next request: the healthy one with the
+// lowest latency, or if none is healthy, the one that has been down longest.
+// Endpoints in tried are skipped; nil means every endpoint has been tried.
+func (p *endpointPool) pick(tried map[*endpoint]bool) (*endpoint, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.endpoints) == 0 {
+		if p.resolveErr != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNoEndpoints, p.resolveErr)
+		}
+		return nil, ErrNoEndpoints
+	}
+
+	var best *endpoint
+	for _, e := range p.endpoints {
+		if tried[e] {
+			continue
+		}
+		if best == nil || better(e, best) {
+			best = e
+		}
+	}
+	return best, nil
+}
+
+// better reports whether a should be preferred to b. p.mu must be held.
+func better(a, b *endpoint) bool {
+	if a.healthy != b.healthy {
+		return a.healthy
+	}
+	if !a.healthy {
+		return a.downSince.Before(b.downSince)
+	}
+	return a.latency < b.latency
+}
+
+// markUp records a successful response from e taking latency
+func (p *endpointPool) markUp(e *endpoint, latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e.healthy, e.lastErr = true, nil
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		// Exponentially weighted, so one slow response doesn't move traffic
+		e.latency = (e.latency*7 + latency*3) / 10
+	}
+}
+
+// markDown takes e out of rotation
+func (p *endpointPool) markDown(e *endpoint, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e.healthy {
+		e.downSince = time.Now()
+	}
+	e.healthy, e.lastErr = false, err
+}
+
+func (p *endpointPool) status() []EndpointStatus {
+	active, _ := p.pick(nil)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out := make([]EndpointStatus, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		s := EndpointStatus{URL: e.url, Healthy: e.healthy, Active: e == active, Latency: e.latency}
+		if !e.healthy {
+			s.DownSince = e.downSince
+			if e.lastErr != nil {
+				s.LastError = e.lastErr.Error()
+			}
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func (p *endpointPool) find(u string) *endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, e := range p.endpoints {
+		if e.url == u {
+			return e
+		}
+	}
+	return nil
+}
+
+func (p *endpointPool) urls() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	urls := make([]string, len(p.endpoints))
+	for i, e := range p.endpoints {
+		urls[i] = e.url
+	}
+	return urls
+}
+
+func (p *endpointPool) close() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// probeLoop probes every endpoint each ProbeInterval, and resolves the SRV
+// name at once and then each ResolveInterval, until the pool is closed
+func (c *Client) probeLoop() {
+	p := c.endpoints
+	probes := time.NewTicker(p.config.ProbeInterval)
+	defer probes.Stop()
+	var resolver *time.Timer
+	var resolves <-chan time.Time
+	if p.config.SRV != "" {
+		resolver = time.NewTimer(0)
+		defer resolver.Stop()
+		resolves = resolver.C
+	}
+	retryDelay := time.Second
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-resolves:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := p.resolve(ctx)
+			cancel()
+
+			select {
+			case <-p.resolved:
+			default:
+				close(p.resolved)
+			}
+
+			next := p.config.ResolveInterval
+			if err != nil {
+				next = min(retryDelay, next)
+				retryDelay *= 2
+			} else {
+				retryDelay = time.Second
+			}
+			resolver.Reset(next)
+		case <-probes.C:
+			var wg sync.WaitGroup
+			for _, u := range p.urls() {
+				wg.Add(1)
+				go func(u string) {
+					defer wg.Done()
+					c.probe(u)
+				}(u)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+// probe checks one endpoint's health endpoint and updates its state. It
+// sends the request directly, so probes neither use up the client's limits
+// nor trip its breakers.
+func (c *Client) probe(u string) {
+	timeout := min(c.endpoints.config.ProbeInterval, 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := c.sendTo(ctx, u, http.MethodGet, "/api/v1/health", nil)
+	if err == nil {
+		discardResponse(resp)
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("health check failed with status %d", resp.StatusCode)
+		}
+	}
+	e := c.endpoints.find(u)
+	if e == nil {
+		return
+	}
+	if err != nil {
+		c.endpoints.markDown(e, err)
+	} else {
+		c.endpoints.markUp(e, time.Since(start))
+	}
+}
+
+// sendFailover sends a request to the best endpoint, moving on to the next at
+// once if the connection can't be made. Failures that may have reached the
+// server, such as 5xx responses, take the endpoint out of rotation but are
+// left to the retry policy.
+func (c *Client) sendFailover(ctx context.Context, method, path string, body *requestBody) (*http.Response, error) {
+	if err := c.endpoints.waitResolved(ctx); err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	tried := make(map[*endpoint]bool)
+	var lastErr error
+	for {
+		e, err := c.endpoints.pick(tried)
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			// Every endpoint failed to connect, or the list changed under us
+			if lastErr == nil {
+				lastErr = ErrNoEndpoints
+			}
+			return nil, lastErr
+		}
+		tried[e] = true
+
+		start := time.Now()
+		resp, err := c.sendTo(ctx, e.url, method, path, body)
+		switch {
+		case err != nil && isTransportError(err) && ctx.Err() == nil:
+			c.endpoints.markDown(e, err)
+			if isDialError(err) {
+				lastErr = err
+				continue
+			}
+		case err == nil && resp.StatusCode >= 500:
+			c.endpoints.markDown(e, fmt.Errorf("status %d", resp.StatusCode))
+		case err == nil:
+			c.endpoints.markUp(e, time.Since(start))
+		}
+		return resp, err
+	}
+}
+
+// isTransportError reports whether err came from the HTTP exchange itself,
+// rather than from the client's own limits, breakers or credentials
+func isTransportError(err error) bool {
+	if isPermanent(err) {
+		// A token endpoint's failure says nothing about the Control Service
+		return false
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// isDialError reports whether err happened while connecting, so the request
+// never reached the server
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// ActiveEndpoint returns the base URL requests currently go to
+func (c *Client) ActiveEndpoint() string {
+	if c.endpoints == nil {
+		return c.baseURL
+	}
+	e, _ := c.endpoints.pick(nil)
+	if e == nil {
+		return ""
+	}
+	return e.url
+}
+
+// Endpoints returns the state of every Control Service endpoint. Without
+// failover it is just the one BaseURL.
+func (c *Client) Endpoints() []EndpointStatus {
+	if c.endpoints == nil {
+		return []EndpointStatus{{URL: c.baseURL, Healthy: true, Active: true}}
+	}
+	return c.endpoints.status()
+}
+
+// Close stops the client's background work, such as failover health probes.
+// The client must not be used afterwards.
+func (c *Client) Close() {
+	if c.endpoints != nil {
+		c.endpoints.close()
+	}
+}
@@ -0,0 +1,219 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newClosedServer returns the URL of a server that no longer accepts
+// connections
+func newClosedServer(t *testing.T) string {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {})
+	srv.Close()
+	return srv.URL
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func endpointStatus(c *Client, u string) EndpointStatus {
+	for _, s := range c.Endpoints() {
+		if s.URL == u {
+			return s
+		}
+	}
+	return EndpointStatus{}
+}
+
+func TestFailoverSkipsClosedEndpoint(t *testing.T) {
+	closed := newClosedServer(t)
+	live := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusOK)
+	})
+	c := NewClient(Config{
+		BaseURL:  closed,
+		Retry:    RetryPolicy{MaxAttempts: 1},
+		Failover: &FailoverConfig{BaseURLs: []string{live.URL}, ProbeInterval: time.Hour},
+	})
+	t.Cleanup(c.Close)
+
+	// The closed endpoint comes first, so it is tried first and skipped
+	// within the one attempt
+	if err := c.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s := endpointStatus(c, closed); s.Healthy || s.LastError == "" || s.DownSince.IsZero() {
+		t.Fatalf("expected the closed endpoint to be down with its error, got %+v", s)
+	}
+	if s := endpointStatus(c, live.URL); !s.Healthy || !s.Active {
+		t.Fatalf("expected the live endpoint to be healthy and active, got %+v", s)
+	}
+	if c.ActiveEndpoint() != live.URL {
+		t.Fatalf("expected %s to be active, got %s", live.URL, c.ActiveEndpoint())
+	}
+
+	if err := c.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if live.Calls() != 2 {
+		t.Fatalf("expected both requests on the live endpoint, got %d", live.Calls())
+	}
+}
+
+func TestFailoverAllEndpointsClosed(t *testing.T) {
+	c := NewClient(Config{
+		BaseURL:  newClosedServer(t),
+		Retry:    RetryPolicy{MaxAttempts: 1},
+		Failover: &FailoverConfig{BaseURLs: []string{newClosedServer(t)}, ProbeInterval: time.Hour},
+	})
+	t.Cleanup(c.Close)
+
+	err := c.HealthCheck(context.Background())
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "dial" {
+		t.Fatalf("expected the last dial error, got %v", err)
+	}
+	for _, s := range c.Endpoints() {
+		if s.Healthy {
+			t.Fatalf("expected every endpoint to be down, got %+v", s)
+		}
+	}
+}
+
+func TestFailoverRecovery(t *testing.T) {
+	var failing int32 = 1
+	flaky := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	live := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusOK)
+	})
+	c := NewClient(Config{
+		BaseURL:  flaky.URL,
+		Retry:    fastRetries,
+		Failover: &FailoverConfig{BaseURLs: []string{live.URL}, ProbeInterval: 20 * time.Millisecond},
+	})
+	t.Cleanup(c.Close)
+
+	// The 503 takes the flaky endpoint out of rotation and the retry goes to
+	// the other one
+	if err := c.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s := endpointStatus(c, flaky.URL); s.Healthy || s.LastError != "status 503" {
+		t.Fatalf("expected the flaky endpoint to be down with status 503, got %+v", s)
+	}
+	if c.ActiveEndpoint() != live.URL {
+		t.Fatalf("expected %s to be active, got %s", live.URL, c.ActiveEndpoint())
+	}
+
+	// Once it answers again, a probe puts it back
+	atomic.StoreInt32(&failing, 0)
+	waitFor(t, "the flaky endpoint to recover", func() bool {
+		return endpointStatus(c, flaky.URL).Healthy
+	})
+	if s := endpointStatus(c, flaky.URL); s.LastError != "" || s.Latency == 0 {
+		t.Fatalf("expected the recovered endpoint to have a latency and no error, got %+v", s)
+	}
+}
+
+func TestFailoverProbes(t *testing.T) {
+	closed := newClosedServer(t)
+	live := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		if r.URL.Path != "/api/v1/health" {
+			t.Errorf("expected probes on the health endpoint, got %s", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	c := NewClient(Config{
+		BaseURL:  closed,
+		Failover: &FailoverConfig{BaseURLs: []string{live.URL}, ProbeInterval: 10 * time.Millisecond},
+	})
+	t.Cleanup(c.Close)
+
+	// No requests are made; the probes alone find the closed endpoint
+	waitFor(t, "the closed endpoint to be probed", func() bool {
+		return !endpointStatus(c, closed).Healthy && live.Calls() > 0
+	})
+	if c.ActiveEndpoint() != live.URL {
+		t.Fatalf("expected %s to be active, got %s", live.URL, c.ActiveEndpoint())
+	}
+
+	// Probes stop with the client
+	c.Close()
+	time.Sleep(30 * time.Millisecond)
+	calls := live.Calls()
+	time.Sleep(50 * time.Millisecond)
+	if live.Calls() != calls {
+		t.Fatalf("expected no probes after Close, got %d more", live.Calls()-calls)
+	}
+}
+
+// stubSRV makes SRV lookups return the host and port of u, or err
+func stubSRV(t *testing.T, u string, err error) {
+	parsed, _ := url.Parse(u)
+	port, _ := strconv.Atoi(parsed.Port())
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if err != nil {
+			return "", nil, err
+		}
+		return "", []*net.SRV{{Target: parsed.Hostname() + ".", Port: uint16(port)}}, nil
+	}
+	t.Cleanup(func() { lookupSRV = net.DefaultResolver.LookupSRV })
+}
+
+func TestFailoverSRV(t *testing.T) {
+	live := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusOK)
+	})
+	stubSRV(t, live.URL, nil)
+	c := NewClient(Config{
+		Failover: &FailoverConfig{SRV: "_control._tcp.test", ProbeInterval: time.Hour},
+	})
+	t.Cleanup(c.Close)
+
+	// With no BaseURLs the request waits for the first lookup
+	if err := c.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if c.ActiveEndpoint() != live.URL {
+		t.Fatalf("expected the SRV target %s, got %s", live.URL, c.ActiveEndpoint())
+	}
+}
+
+func TestFailoverSRVLookupFails(t *testing.T) {
+	stubSRV(t, "", errors.New("lookup failed"))
+	c := NewClient(Config{
+		Retry:    RetryPolicy{MaxAttempts: 1},
+		Failover: &FailoverConfig{SRV: "_control._tcp.test", ProbeInterval: time.Hour},
+	})
+	t.Cleanup(c.Close)
+
+	err := c.HealthCheck(context.Background())
+	if !errors.Is(err, ErrNoEndpoints) {
+		t.Fatalf("expected ErrNoEndpoints, got %v", err)
+	}
+}
@@ -2,7 +2,10 @@ module github.com/bytefreezer/goodies/control-client
 
 go 1.21
 
-require github.com/bytedance/sonic v1.14.2
+require (
+	github.com/bytedance/sonic v1.14.2
+	github.com/klauspost/compress v1.18.0
+)
 
 require (
 	github.com/bytedance/gopkg v0.1.3 // indirect
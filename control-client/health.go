@@ -0,0 +1,221 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Health statuses for reports and component checks
+const (
+	HealthStatusHealthy   = "healthy"
+	HealthStatusDegraded  = "degraded"
+	HealthStatusUnhealthy = "unhealthy"
+	// HealthStatusStopping is sent once, as a service instance shuts down
+	HealthStatusStopping = "stopping"
+)
+
+// HealthReport is a service instance's status, sent to the Control Service
+type HealthReport struct {
+	ServiceName string           `json:"service_name"`
+	InstanceID  string           `json:"instance_id"`
+	Version     string           `json:"version"`
+	Status      string           `json:"status"`
+	Message     string           `json:"message,omitempty"`
+	Checks      []ComponentCheck `json:"checks,omitempty"`
+	// Metrics are point-in-time figures such as queue depth or files
+	// processed, keyed by name
+	Metrics    map[string]float64 `json:"metrics,omitempty"`
+	StartedAt  time.Time          `json:"started_at"`
+	ReportedAt time.Time          `json:"reported_at"`
+}
+
+// ComponentCheck is the status of one of a service's dependencies or
+// subsystems, such as its S3 bucket or work queue
+type ComponentCheck struct {
+	Name      string        `json:"name"`
+	Status    string        `json:"status"`
+	Message   string        `json:"message,omitempty"`
+	Latency   time.Duration `json:"latency_ns,omitempty"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// ReportHealth sends a service instance's health report. ReportedAt is set to
+// now if it is zero.
+func (c *Client) ReportHealth(ctx context.Context, report *HealthReport) error {
+	if report.ReportedAt.IsZero() {
+		r := *report
+		r.ReportedAt = time.Now().UTC()
+		report = &r
+	}
+
+	// A repeated report does no harm, so it is retried like a GET
+	ctx = WithCallOptions(ctx, RetryNonIdempotent())
+	resp, err := c.doRequest(ctx, "POST", "/api/v1/health/reports", report)
+	if err != nil {
+		return err
+	}
+
+	return c.parseResponse(resp, nil)
+}
+
+// HealthReporterConfig configures a HealthReporter
+type HealthReporterConfig struct {
+	ServiceName string
+	InstanceID  string
+	Version     string
+	// Interval is the time between reports (default 30s)
+	Interval time.Duration
+	// Jitter randomly lengthens or shortens each interval by up to this
+	// fraction of it, so instances started together don't report in step
+	// (default 0.1). Set it negative to report at exactly Interval.
+	Jitter float64
+	// Collect fills in the status, checks and metrics for each report. The
+	// reporter sets the service name, instance ID, version and times. If nil,
+	// every report is healthy.
+	Collect func(ctx context.Context) HealthReport
+}
+
+// HealthReporter sends a service instance's health report periodically, and a
+// final HealthStatusStopping report when stopped
+type HealthReporter struct {
+	client    *Client
+	config    HealthReporterConfig
+	startedAt time.Time
+	mu        sync.Mutex
+	stopChan  chan struct{}
+	done      chan struct{}
+	running   bool
+	logFunc   func(format string, args ...interface{})
+}
+
+// NewHealthReporter creates a new health reporter
+func NewHealthReporter(client *Client, config HealthReporterConfig) *HealthReporter {
+	if config.Interval <= 0 {
+		config.Interval = 30 * time.Second
+	}
+	switch {
+	case config.Jitter == 0:
+		config.Jitter = 0.1
+	case config.Jitter < 0:
+		config.Jitter = 0
+	case config.Jitter > 1:
+		config.Jitter = 1
+	}
+	return &HealthReporter{
+		client:    client,
+		config:    config,
+		startedAt: time.Now().UTC(),
+	}
+}
+
+// SetLogFunc sets a custom log function. If not set, logs are discarded.
+func (r *HealthReporter) SetLogFunc(f func(format string, args ...interface{})) {
+	r.logFunc = f
+}
+
+func (r *HealthReporter) log(format string, args ...interface{}) {
+	if r.logFunc != nil {
+		r.logFunc(format, args...)
+	}
+}
+
+// Start sends a first report and then reports periodically in a background
+// goroutine. A stopped reporter can be started again.
+func (r *HealthReporter) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running {
+		return
+	}
+	r.running = true
+	r.stopChan = make(chan struct{})
+	r.done = make(chan struct{})
+
+	go r.reportLoop(r.stopChan, r.done)
+}
+
+// Stop stops periodic reporting and sends a final HealthStatusStopping
+// report, returning once it has been sent or has failed
+func (r *HealthReporter) Stop() {
+	r.mu.Lock()
+	if !r.running {
+		r.mu.Unlock()
+		return
+	}
+	r.running = false
+	close(r.stopChan)
+	done := r.done
+	r.mu.Unlock()
+
+	<-done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	report := r.fill(HealthReport{Status: HealthStatusStopping, Message: "shutting down"})
+	if err := r.client.ReportHealth(ctx, &report); err != nil {
+		r.log("health reporter: final report failed: %v", err)
+	}
+}
+
+func (r *HealthReporter) reportLoop(stopChan <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	for {
+		r.report()
+
+		timer := time.NewTimer(r.nextInterval())
+		select {
+		case <-stopChan:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// nextInterval returns Interval adjusted by a random jitter
+func (r *HealthReporter) nextInterval() time.Duration {
+	spread := (rand.Float64()*2 - 1) * r.config.Jitter
+	return time.Duration(float64(r.config.Interval) * (1 + spread))
+}
+
+func (r *HealthReporter) report() {
+	timeout := min(r.config.Interval, 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	report := HealthReport{Status: HealthStatusHealthy}
+	if r.config.Collect != nil {
+		report = r.config.Collect(ctx)
+	}
+	report = r.fill(report)
+
+	if err := r.client.ReportHealth(ctx, &report); err != nil {
+		r.log("health reporter: report failed (will retry): %v", err)
+	}
+}
+
+// fill sets the fields the reporter owns
+func (r *HealthReporter) fill(report HealthReport) HealthReport {
+	report.ServiceName = r.config.ServiceName
+	report.InstanceID = r.config.InstanceID
+	report.Version = r.config.Version
+	report.StartedAt = r.startedAt
+	report.ReportedAt = time.Now().UTC()
+	if report.Status == "" {
+		report.Status = HealthStatusHealthy
+	}
+	return report
+}
+
+// String returns a description of the reporter for logging
+func (r *HealthReporter) String() string {
+	return fmt.Sprintf("HealthReporter(service=%s, instance=%s, interval=%v)", r.config.ServiceName, r.config.InstanceID, r.config.Interval)
+}
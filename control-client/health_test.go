@@ -0,0 +1,113 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHealthReporterJitter(t *testing.T) {
+	cases := map[string]struct {
+		jitter float64
+		spread float64
+	}{
+		"default":  {0, 0.1},
+		"disabled": {-1, 0},
+		"custom":   {0.5, 0.5},
+		"capped":   {3, 1},
+	}
+	for name, tc := range cases {
+		r := NewHealthReporter(nil, HealthReporterConfig{Interval: time.Second, Jitter: tc.jitter})
+		lo := time.Duration(float64(time.Second) * (1 - tc.spread))
+		hi := time.Duration(float64(time.Second) * (1 + tc.spread))
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 200; i++ {
+			d := r.nextInterval()
+			if d < lo || d > hi {
+				t.Fatalf("%s: expected an interval in [%v, %v], got %v", name, lo, hi, d)
+			}
+			seen[d] = true
+		}
+		if tc.spread == 0 && len(seen) != 1 {
+			t.Fatalf("%s: expected the exact interval every time, got %d different ones", name, len(seen))
+		}
+		if tc.spread > 0 && len(seen) < 2 {
+			t.Fatalf("%s: expected jittered intervals, got the same one every time", name)
+		}
+	}
+}
+
+func TestHealthReporterReports(t *testing.T) {
+	var mu sync.Mutex
+	var reports []HealthReport
+	var times []time.Time
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/health/reports" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var report HealthReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Errorf("expected a JSON report, got %v", err)
+		}
+		mu.Lock()
+		reports = append(reports, report)
+		times = append(times, time.Now())
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	const interval = 20 * time.Millisecond
+	r := NewHealthReporter(NewClient(Config{BaseURL: srv.URL}), HealthReporterConfig{
+		ServiceName: "piper",
+		InstanceID:  "piper-1",
+		Version:     "1.2.3",
+		Interval:    interval,
+		Jitter:      -1,
+		Collect: func(ctx context.Context) HealthReport {
+			return HealthReport{
+				Status:  HealthStatusDegraded,
+				Checks:  []ComponentCheck{{Name: "s3", Status: HealthStatusDegraded, Message: "slow"}},
+				Metrics: map[string]float64{"queue_depth": 7},
+			}
+		},
+	})
+	r.Start()
+	waitFor(t, "three reports", func() bool { return srv.Calls() >= 3 })
+	r.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 1; i < len(reports)-1; i++ {
+		if gap := times[i].Sub(times[i-1]); gap < interval-5*time.Millisecond {
+			t.Fatalf("expected reports %v apart, got %v", interval, gap)
+		}
+	}
+	first := reports[0]
+	if first.ServiceName != "piper" || first.InstanceID != "piper-1" || first.Version != "1.2.3" {
+		t.Fatalf("expected the reporter's identity, got %+v", first)
+	}
+	if first.Status != HealthStatusDegraded || len(first.Checks) != 1 || first.Checks[0].Message != "slow" || first.Metrics["queue_depth"] != 7 {
+		t.Fatalf("expected the collected status, checks and metrics, got %+v", first)
+	}
+	if first.StartedAt.IsZero() || first.ReportedAt.Before(first.StartedAt) {
+		t.Fatalf("expected the start and report times, got %v and %v", first.StartedAt, first.ReportedAt)
+	}
+
+	last := reports[len(reports)-1]
+	if last.Status != HealthStatusStopping || !last.StartedAt.Equal(first.StartedAt) {
+		t.Fatalf("expected a final stopping report from the same instance, got %+v", last)
+	}
+
+	// Nothing more is sent once stopped
+	n := srv.Calls()
+	time.Sleep(2 * interval)
+	if srv.Calls() != n {
+		t.Fatalf("expected no reports after Stop, got %d more", srv.Calls()-n)
+	}
+}
@@ -0,0 +1,261 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// CacheConfig enables caching of GET responses that carry an ETag or
+// Last-Modified header. Cached responses are always revalidated with
+// If-None-Match or If-Modified-Since, and served from the cache when the
+// Control Service answers 304 Not Modified, so they are never stale.
+type CacheConfig struct {
+	// MaxBytes bounds the memory held by cached response bodies (default
+	// 16 MiB). The least recently used are evicted first.
+	MaxBytes int64
+	// MaxEntryBytes is the largest response body that is cached (default
+	// MaxBytes/16). Larger ones are passed through, and streamed as usual.
+	MaxEntryBytes int64
+}
+
+// CacheStats counts the HTTP cache's use
+type CacheStats struct {
+	// Hits are GETs answered 304 and served from the cache
+	Hits uint64
+	// Misses are GETs the Control Service answered in full
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Bytes     int64
+}
+
+// BypassCache sends a GET around the cache: it goes without the cache's
+// validators, so the Control Service answers in full, and the response is not
+// stored. Whatever was cached for the path is left as it was.
+func BypassCache() CallOption {
+	return func(co *callOptions) {
+		co.bypassCache = true
+	}
+}
+
+// CacheStats returns the HTTP cache's counters. They are zero unless
+// Config.Cache is set.
+func (c *Client) CacheStats() CacheStats {
+	if c.cache == nil {
+		return CacheStats{}
+	}
+	return c.cache.stats()
+}
+
+// cacheEntry is a cached response
+type cacheEntry struct {
+	key          string
+	etag         string
+	lastModified string
+	header       http.Header
+	body         []byte
+}
+
+func (e *cacheEntry) size() int64 {
+	return int64(len(e.key) + len(e.body))
+}
+
+// response rebuilds the cached response
+func (e *cacheEntry) response() *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+	}
+}
+
+// responseCache is an LRU cache of GET responses keyed by path
+type responseCache struct {
+	maxBytes      int64
+	maxEntryBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is most recently used
+	bytes   int64
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func newResponseCache(config CacheConfig) *responseCache {
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = 16 << 20
+	}
+	if config.MaxEntryBytes <= 0 || config.MaxEntryBytes > config.MaxBytes {
+		config.MaxEntryBytes = config.MaxBytes / 16
+	}
+	return &responseCache{
+		maxBytes:      config.MaxBytes,
+		maxEntryBytes: config.MaxEntryBytes,
+		entries:       make(map[string]*list.Element),
+		lru:           list.New(),
+	}
+}
+
+func (rc *responseCache) get(key string) *cacheEntry {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	el := rc.entries[key]
+	if el == nil {
+		return nil
+	}
+	rc.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry)
+}
+
+func (rc *responseCache) put(e *cacheEntry) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if el := rc.entries[e.key]; el != nil {
+		rc.removeElement(el)
+	}
+	rc.entries[e.key] = rc.lru.PushFront(e)
+	rc.bytes += e.size()
+	for rc.bytes > rc.maxBytes {
+		rc.removeElement(rc.lru.Back())
+		rc.evictions++
+	}
+}
+
+func (rc *responseCache) remove(key string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if el := rc.entries[key]; el != nil {
+		rc.removeElement(el)
+	}
+}
+
+// removeElement drops an entry. rc.mu must be held.
+func (rc *responseCache) removeElement(el *list.Element) {
+	e := rc.lru.Remove(el).(*cacheEntry)
+	delete(rc.entries, e.key)
+	rc.bytes -= e.size()
+}
+
+func (rc *responseCache) count(hit bool) {
+	rc.mu.Lock()
+	if hit {
+		rc.hits++
+	} else {
+		rc.misses++
+	}
+	rc.mu.Unlock()
+}
+
+func (rc *responseCache) stats() CacheStats {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return CacheStats{
+		Hits:      rc.hits,
+		Misses:    rc.misses,
+		Evictions: rc.evictions,
+		Entries:   rc.lru.Len(),
+		Bytes:     rc.bytes,
+	}
+}
+
+type cacheValidatorsKey struct{}
+
+// setCacheValidators sets the conditional headers for the cached response
+// ctx carries, if any
+func setCacheValidators(ctx context.Context, req *http.Request) {
+	e, ok := ctx.Value(cacheValidatorsKey{}).(*cacheEntry)
+	if !ok {
+		return
+	}
+	if e.etag != "" {
+		req.Header.Set("If-None-Match", e.etag)
+	}
+	if e.lastModified != "" {
+		req.Header.Set("If-Modified-Since", e.lastModified)
+	}
+}
+
+// cachedGet makes a GET through the cache: it revalidates any cached response,
+// serves it on 304, and caches a new 200 response that has validators. Health
+// checks and calls with BypassCache go straight to the Control Service.
+func (c *Client) cachedGet(ctx context.Context, path string) (*http.Response, error) {
+	if callOptionsFromContext(ctx).bypassCache || endpointGroup(path) == EndpointGroupHealth {
+		return c.retryRequest(ctx, http.MethodGet, path, nil)
+	}
+
+	cached := c.cache.get(path)
+	if cached != nil {
+		ctx = context.WithValue(ctx, cacheValidatorsKey{}, cached)
+	}
+
+	resp, err := c.retryRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		discardResponse(resp)
+		c.cache.count(true)
+		return cached.response(), nil
+	}
+	c.cache.count(false)
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	return c.cache.store(path, resp), nil
+}
+
+// store caches resp if it has validators and is small enough, returning a
+// response the caller can read in its place
+func (rc *responseCache) store(key string, resp *http.Response) *http.Response {
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	if (etag == "" && lastModified == "") || strings.Contains(resp.Header.Get("Cache-Control"), "no-store") {
+		rc.remove(key)
+		return resp
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, rc.maxEntryBytes+1))
+	if err != nil || int64(len(body)) > rc.maxEntryBytes {
+		// Hand back what was read followed by the rest, uncached
+		rc.remove(key)
+		resp.Body = &splicedBody{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+		return resp
+	}
+	resp.Body.Close()
+
+	rc.put(&cacheEntry{
+		key:          key,
+		etag:         etag,
+		lastModified: lastModified,
+		header:       resp.Header.Clone(),
+		body:         body,
+	})
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp
+}
+
+// splicedBody reads from Reader and closes Closer
+type splicedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,221 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// etagHandler serves accounts with an ETag, answering 304 when the request
+// carries it
+func etagHandler(w http.ResponseWriter, r *http.Request, call int) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/accounts/")
+	etag := `"` + id + `-v1"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	fmt.Fprintf(w, `{"id":%q,"name":"account %s"}`, id, id)
+}
+
+func TestCacheNotModified(t *testing.T) {
+	srv := newTestServer(t, etagHandler)
+	c := NewClient(Config{BaseURL: srv.URL, Cache: &CacheConfig{}})
+
+	for i := 0; i < 3; i++ {
+		account, err := c.GetAccount(context.Background(), "acc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if account.Name != "account acc" {
+			t.Fatalf("expected the account's name, got %q", account.Name)
+		}
+	}
+
+	stats := c.CacheStats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Fatalf("expected 2 hits, 1 miss and 1 entry, got %+v", stats)
+	}
+	if srv.Calls() != 3 {
+		t.Fatalf("expected every GET to be revalidated, got %d requests", srv.Calls())
+	}
+}
+
+func TestCacheNoValidators(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.Write([]byte(`{"id":"acc"}`))
+	})
+	c := NewClient(Config{BaseURL: srv.URL, Cache: &CacheConfig{}})
+
+	if _, err := c.GetAccount(context.Background(), "acc"); err != nil {
+		t.Fatal(err)
+	}
+	if stats := c.CacheStats(); stats.Entries != 0 {
+		t.Fatalf("expected a response without validators not to be cached, got %+v", stats)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	srv := newTestServer(t, etagHandler)
+	// Each entry is 47 bytes, its key and body, so three fit
+	c := NewClient(Config{BaseURL: srv.URL, Cache: &CacheConfig{MaxBytes: 150, MaxEntryBytes: 100}})
+	ctx := context.Background()
+
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := c.GetAccount(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Touch a, so b is the least recently used
+	if _, err := c.GetAccount(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetAccount(ctx, "d"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := c.CacheStats()
+	if stats.Evictions != 1 || stats.Entries != 3 || stats.Bytes > 150 {
+		t.Fatalf("expected 1 eviction leaving 3 entries within 150 bytes, got %+v", stats)
+	}
+	for _, id := range []string{"a", "c", "d"} {
+		if c.cache.get("/api/v1/accounts/"+id) == nil {
+			t.Fatalf("expected %s to be cached", id)
+		}
+	}
+	if c.cache.get("/api/v1/accounts/b") != nil {
+		t.Fatal("expected b, the least recently used, to be evicted")
+	}
+}
+
+func TestCacheOversizedEntry(t *testing.T) {
+	large := `{"id":"acc","name":"` + strings.Repeat("x", 500) + `"}`
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(large))
+	})
+	c := NewClient(Config{
+		BaseURL: srv.URL,
+		Cache:   &CacheConfig{MaxBytes: 1 << 20, MaxEntryBytes: 100},
+		Limits:  LimitConfig{MaxInFlight: 1},
+	})
+
+	resp, err := c.doRequest(context.Background(), http.MethodGet, "/api/v1/accounts/acc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The part read to find the size comes first, followed by the rest
+	if string(body) != large {
+		t.Fatalf("expected the whole body, got %d bytes", len(body))
+	}
+	if stats := c.CacheStats(); stats.Entries != 0 {
+		t.Fatalf("expected an oversized response not to be cached, got %+v", stats)
+	}
+
+	// Closing the spliced body closes the original, giving back its slot
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := c.GetAccount(ctx, "acc"); err != nil {
+		t.Fatalf("expected the in-flight slot to be free, got %v", err)
+	}
+}
+
+func TestSplicedBodyClose(t *testing.T) {
+	rc := &closeRecorder{Reader: strings.NewReader("rest")}
+	body := &splicedBody{Reader: io.MultiReader(bytes.NewReader([]byte("head-")), rc), Closer: rc}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "head-rest" {
+		t.Fatalf("expected head-rest, got %q", data)
+	}
+	body.Close()
+	if !rc.closed {
+		t.Fatal("expected closing the spliced body to close the original")
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestCacheBypass(t *testing.T) {
+	var conditional int
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		if r.Header.Get("If-None-Match") != "" {
+			conditional++
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, call))
+		fmt.Fprintf(w, `{"id":"acc","name":"v%d"}`, call)
+	})
+	c := NewClient(Config{BaseURL: srv.URL, Cache: &CacheConfig{}})
+
+	if _, err := c.GetAccount(context.Background(), "acc"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithCallOptions(context.Background(), BypassCache())
+	account, err := c.GetAccount(ctx, "acc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Name != "v2" {
+		t.Fatalf("expected a fresh response, got %q", account.Name)
+	}
+	if conditional != 0 {
+		t.Fatal("expected a bypassed GET to go without validators")
+	}
+
+	// The bypassed response is not stored, so the cache still holds v1
+	e := c.cache.get("/api/v1/accounts/acc")
+	if e == nil || e.etag != `"v1"` {
+		t.Fatalf("expected the first response to stay cached, got %+v", e)
+	}
+	if stats := c.CacheStats(); stats.Hits != 0 || stats.Misses != 1 {
+		t.Fatalf("expected a bypassed GET not to count, got %+v", stats)
+	}
+}
+
+func TestCacheSkipsHealth(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		if r.Header.Get("If-None-Match") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"health"`)
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+	c := NewClient(Config{BaseURL: srv.URL, Cache: &CacheConfig{}})
+
+	for i := 0; i < 2; i++ {
+		if err := c.HealthCheck(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := c.CacheStats(); stats.Entries != 0 || stats.Hits != 0 || stats.Misses != 0 {
+		t.Fatalf("expected health checks to bypass the cache, got %+v", stats)
+	}
+}
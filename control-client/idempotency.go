@@ -0,0 +1,63 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrIdempotencyKeyReused matches the error the Control Service returns when
+// an Idempotency-Key is sent again with a different request body. It means
+// the key was reused by mistake, not that the call should be retried.
+var ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+
+// IdempotencyKey sends key as the call's Idempotency-Key, in place of a
+// generated one. Use it to make a call that is repeated across process
+// restarts, such as creating a job for a given input, safe to repeat.
+func IdempotencyKey(key string) CallOption {
+	return func(co *callOptions) {
+		co.idempotencyKey = key
+	}
+}
+
+// needsIdempotencyKey reports whether requests with method get an
+// Idempotency-Key
+func needsIdempotencyKey(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut
+}
+
+type idempotencyKeyKey struct{}
+
+// withIdempotencyKey picks the key for a call, so every attempt sends the same
+// one, and returns a context carrying it for send
+func withIdempotencyKey(ctx context.Context, method string, opts callOptions) context.Context {
+	if !needsIdempotencyKey(method) {
+		return ctx
+	}
+	key := opts.idempotencyKey
+	if key == "" {
+		key = newUUID()
+	}
+	return context.WithValue(ctx, idempotencyKeyKey{}, key)
+}
+
+// setIdempotencyKey sets the header for the call ctx belongs to
+func setIdempotencyKey(ctx context.Context, req *http.Request) {
+	if key, ok := ctx.Value(idempotencyKeyKey{}).(string); ok {
+		req.Header.Set("Idempotency-Key", key)
+	}
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,92 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+func TestIdempotencyKeyAcrossRetries(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		mu.Unlock()
+		// Every call fails twice before it succeeds
+		if call%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"id": "acc"}`))
+	})
+	c := NewClient(Config{BaseURL: srv.URL, Retry: fastRetries, RetryPostsWithIdempotencyKey: true})
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.CreateAccount(context.Background(), CreateAccountRequest{Name: "a"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(keys) != 6 {
+		t.Fatalf("expected 3 attempts per call, got %d requests", len(keys))
+	}
+
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for i, key := range keys {
+		if !uuid.MatchString(key) {
+			t.Fatalf("expected a version 4 UUID, got %q", key)
+		}
+		if key != keys[i/3*3] {
+			t.Fatalf("expected every attempt of a call to send the same key, got %v", keys)
+		}
+	}
+	if keys[0] == keys[3] {
+		t.Fatalf("expected each call to get its own key, got %q twice", keys[0])
+	}
+}
+
+func TestIdempotencyKeyOption(t *testing.T) {
+	var key string
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		key = r.Header.Get("Idempotency-Key")
+		w.Write([]byte(`{"id": "acc"}`))
+	})
+	c := NewClient(Config{BaseURL: srv.URL})
+
+	ctx := WithCallOptions(context.Background(), IdempotencyKey("create-acc"))
+	if _, err := c.CreateAccount(ctx, CreateAccountRequest{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if key != "create-acc" {
+		t.Fatalf("expected the given key, got %q", key)
+	}
+
+	if _, err := c.GetAccount(context.Background(), "acc"); err != nil {
+		t.Fatal(err)
+	}
+	if key != "" {
+		t.Fatalf("expected no key on a GET, got %q", key)
+	}
+}
+
+func TestIdempotencyKeyReused(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"code": "idempotency_key_reused", "message": "request differs"}`))
+	})
+	c := NewClient(Config{BaseURL: srv.URL, Retry: fastRetries, RetryPostsWithIdempotencyKey: true})
+
+	_, err := c.CreateAccount(context.Background(), CreateAccountRequest{Name: "a"})
+	if !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Fatalf("expected ErrIdempotencyKeyReused, got %v", err)
+	}
+	if srv.Calls() != 1 {
+		t.Fatalf("expected no retry, got %d requests", srv.Calls())
+	}
+}
@@ -0,0 +1,188 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Service types for instance registration
+const (
+	ServiceTypeReceiver = "receiver"
+	ServiceTypePiper    = "piper"
+	ServiceTypePacker   = "packer"
+)
+
+// ServiceInstance is a registered instance of a ByteFreezer service
+type ServiceInstance struct {
+	ID            string    `json:"id"`
+	ServiceType   string    `json:"service_type"`
+	Address       string    `json:"address"`
+	Version       string    `json:"version"`
+	Capabilities  []string  `json:"capabilities"`
+	RegisteredAt  time.Time `json:"registered_at"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+}
+
+// RegisterInstanceRequest represents a request to register a service instance
+type RegisterInstanceRequest struct {
+	ID          string `json:"id"`
+	ServiceType string `json:"service_type"`
+	// Address is where other services can reach the instance, such as
+	// host:port
+	Address      string   `json:"address"`
+	Version      string   `json:"version"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	// TTLSeconds is how long the instance stays registered without a
+	// heartbeat; zero uses the Control Service's default
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+}
+
+// RegisterInstance registers a service instance, or updates its registration
+// if the ID is already registered
+func (c *Client) RegisterInstance(ctx context.Context, req RegisterInstanceRequest) (*ServiceInstance, error) {
+	path := fmt.Sprintf("/api/v1/instances/%s", url.PathEscape(req.ID))
+	resp, err := c.doRequest(ctx, "PUT", path, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var instance ServiceInstance
+	if err := c.parseResponse(resp, &instance); err != nil {
+		return nil, err
+	}
+
+	return &instance, nil
+}
+
+// UpdateInstanceHeartbeat keeps a registered instance alive. It returns an
+// error matching ErrNotFound if the registration has expired.
+func (c *Client) UpdateInstanceHeartbeat(ctx context.Context, instanceID string) error {
+	path := fmt.Sprintf("/api/v1/instances/%s/heartbeat", url.PathEscape(instanceID))
+	resp, err := c.doRequest(ctx, "PUT", path, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.parseResponse(resp, nil)
+}
+
+// DeregisterInstance removes a service instance's registration
+func (c *Client) DeregisterInstance(ctx context.Context, instanceID string) error {
+	path := fmt.Sprintf("/api/v1/instances/%s", url.PathEscape(instanceID))
+	resp, err := c.doRequest(ctx, "DELETE", path, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.parseResponse(resp, nil)
+}
+
+// ListInstances returns the live instances of a service type, or of every
+// service if serviceType is empty
+func (c *Client) ListInstances(ctx context.Context, serviceType string) ([]ServiceInstance, error) {
+	params := url.Values{}
+	if serviceType != "" {
+		params.Set("service_type", serviceType)
+	}
+
+	it := newIterator(ctx, PageOptions{Limit: listAllPageSize}, func(ctx context.Context, opts PageOptions) (*Page[ServiceInstance], error) {
+		pageParams := url.Values{}
+		for k, v := range params {
+			pageParams[k] = v
+		}
+		return getPage[ServiceInstance](ctx, c, "/api/v1/instances", pageParams, opts)
+	})
+	defer it.Close()
+
+	var instances []ServiceInstance
+	for it.Next() {
+		instances = append(instances, it.Item())
+	}
+	return instances, it.Err()
+}
+
+// KeepInstanceRegistered registers an instance and sends a heartbeat every
+// interval until ctx is done, then deregisters it. If a heartbeat finds the
+// registration expired, the instance is registered again. An interval of zero
+// or less defaults to a third of req.TTLSeconds, or 30s without a TTL. Run it
+// in its own goroutine; it returns the error from the first registration, or
+// nil.
+func (c *Client) KeepInstanceRegistered(ctx context.Context, req RegisterInstanceRequest, interval time.Duration) error {
+	if interval <= 0 {
+		interval = 30 * time.Second
+		if req.TTLSeconds > 0 {
+			interval = max(time.Duration(req.TTLSeconds)*time.Second/3, time.Second)
+		}
+	}
+
+	if _, err := c.RegisterInstance(ctx, req); err != nil {
+		return fmt.Errorf("failed to register instance: %w", err)
+	}
+	defer func() {
+		// ctx is done, so deregister on a fresh one
+		dctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = c.DeregisterInstance(dctx, req.ID)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := c.UpdateInstanceHeartbeat(ctx, req.ID)
+			if errors.Is(err, ErrNotFound) {
+				_, _ = c.RegisterInstance(ctx, req)
+			}
+			// Other failures are left to the next heartbeat
+		}
+	}
+}
+
+// StableInstanceID returns an ID for this process, of the form
+// <serviceType>-<name>-<pid>. The name is the Kubernetes pod name from the
+// POD_NAME environment variable if set, else the hostname. The ID is the same
+// every time it is computed in a process, and across restarts of a container
+// whose entrypoint keeps its PID.
+func StableInstanceID(serviceType string) string {
+	name := os.Getenv("POD_NAME")
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	if name == "" {
+		name = "unknown"
+	}
+
+	parts := []string{sanitizeIDPart(serviceType), sanitizeIDPart(name), strconv.Itoa(os.Getpid())}
+	if parts[0] == "" {
+		parts = parts[1:]
+	}
+	return strings.Join(parts, "-")
+}
+
+// sanitizeIDPart lowercases s and replaces anything but letters, digits, dots
+// and dashes, so the ID is safe in URLs and lock owner strings
+func sanitizeIDPart(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		default:
+			return '-'
+		}
+	}, s)
+}
@@ -0,0 +1,151 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInstanceRequests(t *testing.T) {
+	var got []string
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		got = append(got, r.Method+" "+r.URL.EscapedPath())
+		switch {
+		case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/heartbeat"):
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPut:
+			var req RegisterInstanceRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("expected a JSON request, got %v", err)
+			}
+			if req.ServiceType != ServiceTypePiper || req.TTLSeconds != 90 || len(req.Capabilities) != 1 {
+				t.Errorf("expected the registration request, got %+v", req)
+			}
+			fmt.Fprintf(w, `{"id": %q, "service_type": %q, "address": %q, "capabilities": ["parquet"], "registered_at": "2026-10-18T12:00:00Z"}`,
+				req.ID, req.ServiceType, req.Address)
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	c := NewClient(Config{BaseURL: srv.URL})
+	ctx := context.Background()
+
+	instance, err := c.RegisterInstance(ctx, RegisterInstanceRequest{
+		ID:           "piper/1",
+		ServiceType:  ServiceTypePiper,
+		Address:      "10.0.0.1:8080",
+		Capabilities: []string{"parquet"},
+		TTLSeconds:   90,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.ID != "piper/1" || instance.Address != "10.0.0.1:8080" || instance.Capabilities[0] != "parquet" ||
+		!instance.RegisteredAt.Equal(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the decoded instance, got %+v", instance)
+	}
+
+	if err := c.UpdateInstanceHeartbeat(ctx, "piper/1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an expired registration, got %v", err)
+	}
+	if err := c.DeregisterInstance(ctx, "piper/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"PUT /api/v1/instances/piper%2F1",
+		"PUT /api/v1/instances/piper%2F1/heartbeat",
+		"DELETE /api/v1/instances/piper%2F1",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected requests %v, got %v", want, got)
+	}
+}
+
+func TestListInstances(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		q := r.URL.Query()
+		if r.URL.Path != "/api/v1/instances" || q.Get("service_type") != ServiceTypePacker || q.Get("limit") != "500" {
+			t.Errorf("unexpected request for %s", r.URL)
+		}
+		// Two pages, by offset
+		if q.Get("offset") == "" {
+			w.Write([]byte(`{"items": [{"id": "packer-1", "service_type": "packer"}], "total": 2}`))
+			return
+		}
+		w.Write([]byte(`{"items": [{"id": "packer-2", "service_type": "packer"}], "total": 2}`))
+	})
+	c := NewClient(Config{BaseURL: srv.URL})
+
+	instances, err := c.ListInstances(context.Background(), ServiceTypePacker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 2 || instances[0].ID != "packer-1" || instances[1].ID != "packer-2" {
+		t.Fatalf("expected both pages of instances, got %+v", instances)
+	}
+}
+
+func TestKeepInstanceRegistered(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		mu.Lock()
+		got = append(got, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		// The first heartbeat finds the registration expired
+		if call == 2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"id": "piper-1"}`))
+	})
+	c := NewClient(Config{BaseURL: srv.URL})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.KeepInstanceRegistered(ctx, RegisterInstanceRequest{ID: "piper-1", ServiceType: ServiceTypePiper}, 10*time.Millisecond)
+	}()
+	waitFor(t, "a heartbeat after registering again", func() bool { return srv.Calls() >= 4 })
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"PUT /api/v1/instances/piper-1",
+		"PUT /api/v1/instances/piper-1/heartbeat",
+		"PUT /api/v1/instances/piper-1",
+		"PUT /api/v1/instances/piper-1/heartbeat",
+	}
+	if len(got) < len(want) || strings.Join(got[:len(want)], "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected %v and then heartbeats, got %v", want, got)
+	}
+	if !strings.Contains(strings.Join(got, "\n"), "DELETE /api/v1/instances/piper-1") {
+		t.Fatalf("expected the instance to be deregistered, got %v", got)
+	}
+}
+
+func TestStableInstanceID(t *testing.T) {
+	t.Setenv("POD_NAME", "Piper_Pod.7")
+	want := fmt.Sprintf("piper-piper-pod.7-%d", os.Getpid())
+	if id := StableInstanceID(ServiceTypePiper); id != want {
+		t.Fatalf("expected %s, got %s", want, id)
+	}
+	if id := StableInstanceID(""); id != want[len("piper-"):] {
+		t.Fatalf("expected no leading dash without a service type, got %s", id)
+	}
+}
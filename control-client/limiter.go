@@ -0,0 +1,173 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// LimitConfig limits how hard the client drives the Control Service. A zero
+// field means no limit.
+type LimitConfig struct {
+	// RequestsPerSecond is the sustained request rate (token bucket)
+	RequestsPerSecond float64
+	// Burst is the number of requests that may be sent at once above the
+	// sustained rate (default: RequestsPerSecond rounded up, at least 1)
+	Burst int
+	// MaxInFlight caps the number of requests outstanding at once. A request
+	// counts until its response body is closed.
+	MaxInFlight int
+}
+
+// limiter applies one LimitConfig
+type limiter struct {
+	bucket *tokenBucket
+	sem    chan struct{}
+}
+
+func newLimiter(config LimitConfig) *limiter {
+	if config.RequestsPerSecond <= 0 && config.MaxInFlight <= 0 {
+		return nil
+	}
+	l := &limiter{}
+	if config.RequestsPerSecond > 0 {
+		burst := config.Burst
+		if burst <= 0 {
+			burst = max(1, int(math.Ceil(config.RequestsPerSecond)))
+		}
+		l.bucket = &tokenBucket{
+			rate:   config.RequestsPerSecond,
+			burst:  float64(burst),
+			tokens: float64(burst),
+			last:   time.Now(),
+		}
+	}
+	if config.MaxInFlight > 0 {
+		l.sem = make(chan struct{}, config.MaxInFlight)
+	}
+	return l
+}
+
+// acquire waits for a token and an in-flight slot. The returned function
+// gives the slot back.
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	if l.bucket != nil {
+		if err := l.bucket.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if l.sem == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for an in-flight slot: %w", ctx.Err())
+	}
+	var once sync.Once
+	return func() { once.Do(func() { <-l.sem }) }, nil
+}
+
+// tokenBucket is a token bucket rate limiter. Waiters reserve their token up
+// front, so they are served in order rather than racing for refills.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) wait(ctx context.Context) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	err := ctx.Err()
+	if deadline, ok := ctx.Deadline(); ok && err == nil && time.Until(deadline) < delay {
+		err = context.DeadlineExceeded
+	}
+	if err == nil {
+		err = sleepContext(ctx, delay)
+	}
+	if err != nil {
+		// Hand the reservation back for the next waiter
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return fmt.Errorf("waiting for rate limit: %w", err)
+	}
+	return nil
+}
+
+// limiters holds the global limiter and the per-endpoint-group ones
+type limiters struct {
+	global *limiter
+	groups map[string]*limiter
+}
+
+func newLimiters(global LimitConfig, groups map[string]LimitConfig) *limiters {
+	ls := &limiters{global: newLimiter(global), groups: make(map[string]*limiter)}
+	for group, config := range groups {
+		if l := newLimiter(config); l != nil {
+			ls.groups[group] = l
+		}
+	}
+	if ls.global == nil && len(ls.groups) == 0 {
+		return nil
+	}
+	return ls
+}
+
+// acquire waits on the group's limiter and then the global one
+func (ls *limiters) acquire(ctx context.Context, group string) (func(), error) {
+	var releases []func()
+	release := func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+
+	for _, l := range []*limiter{ls.groups[group], ls.global} {
+		if l == nil {
+			continue
+		}
+		r, err := l.acquire(ctx)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+	return release, nil
+}
+
+// releaseOnClose gives back an in-flight slot when the response body is closed
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.release()
+	return err
+}
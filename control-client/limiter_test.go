@@ -0,0 +1,141 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketCancelWhileWaiting(t *testing.T) {
+	l := newLimiter(LimitConfig{RequestsPerSecond: 1, Burst: 1})
+	if _, err := l.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := l.acquire(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected the wait to end with the context, it took %v", elapsed)
+	}
+
+	// The cancelled waiter's reservation is handed back, so the next waiter
+	// isn't pushed a further second back
+	l.bucket.mu.Lock()
+	tokens := l.bucket.tokens
+	l.bucket.mu.Unlock()
+	if tokens < -0.5 {
+		t.Fatalf("expected the reserved token to be given back, bucket holds %.2f", tokens)
+	}
+}
+
+func TestTokenBucketDeadlineTooSoon(t *testing.T) {
+	l := newLimiter(LimitConfig{RequestsPerSecond: 1, Burst: 1})
+	if _, err := l.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := l.acquire(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 25*time.Millisecond {
+		t.Fatalf("expected to fail at once when the deadline is too soon, it took %v", elapsed)
+	}
+}
+
+func TestLimiterSlotCancel(t *testing.T) {
+	l := newLimiter(LimitConfig{MaxInFlight: 1})
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded while the slot is taken, got %v", err)
+	}
+
+	// Releasing twice gives back only the one slot
+	release()
+	release()
+	if _, err := l.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx); err == nil {
+		t.Fatal("expected the limit to hold after a double release")
+	}
+}
+
+func TestLimitersGroupSlotReturnedOnFailure(t *testing.T) {
+	ls := newLimiters(LimitConfig{MaxInFlight: 1}, map[string]LimitConfig{
+		EndpointGroupJobs: {MaxInFlight: 1},
+	})
+	release, err := ls.acquire(context.Background(), EndpointGroupLocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The jobs slot is free but the global one isn't
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := ls.acquire(ctx, EndpointGroupJobs); err == nil {
+		t.Fatal("expected the global limit to hold")
+	}
+	release()
+
+	// The failed call must have given the jobs slot back
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := ls.acquire(ctx, EndpointGroupJobs); err != nil {
+		t.Fatalf("expected the jobs slot to be free, got %v", err)
+	}
+}
+
+func TestLimiterReleasedOnBodyClose(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.Write([]byte(`{"id":"acc"}`))
+	})
+	c := NewClient(Config{BaseURL: srv.URL, Limits: LimitConfig{MaxInFlight: 1}})
+
+	resp, err := c.doRequest(context.Background(), http.MethodGet, "/api/v1/accounts/acc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The slot is held while the body is open, even once it has been read
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetAccount(ctx, "acc"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the request to wait for the open body, got %v", err)
+	}
+
+	resp.Body.Close()
+	if _, err := c.GetAccount(context.Background(), "acc"); err != nil {
+		t.Fatalf("expected the slot to be free once the body was closed, got %v", err)
+	}
+	if srv.Calls() != 2 {
+		t.Fatalf("expected 2 requests to reach the server, got %d", srv.Calls())
+	}
+}
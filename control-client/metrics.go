@@ -0,0 +1,310 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsRecorder receives measurements of the client's requests. Routes are
+// path templates such as /api/v1/piper/locks/files/{tenant}/{dataset}/{key},
+// so series don't multiply per tenant or key. Implementations must be safe
+// for concurrent use and must not block.
+type MetricsRecorder interface {
+	// RequestStarted is called as each attempt is sent
+	RequestStarted(method, route string)
+	// RequestFinished is called once the attempt's response body is closed,
+	// or straight away if it failed without a response
+	RequestFinished(m RequestMetrics)
+	// RequestRetried is called before each retry of a call
+	RequestRetried(method, route string)
+}
+
+// RequestMetrics describes one finished attempt
+type RequestMetrics struct {
+	Method string
+	Route  string
+	// StatusCode is 0 if there was no response
+	StatusCode int
+	// Duration is the time until the response headers arrived
+	Duration time.Duration
+	// BytesSent and BytesReceived are body sizes as sent over the wire, and
+	// the Uncompressed sizes are the JSON they carried. The two differ only
+	// for compressed bodies; their ratio is the compression ratio.
+	BytesSent                 int64
+	BytesReceived             int64
+	UncompressedBytesSent     int64
+	UncompressedBytesReceived int64
+}
+
+// StatusClass returns "2xx", "4xx" and so on, or "error" if there was no
+// response
+func (m RequestMetrics) StatusClass() string {
+	if m.StatusCode == 0 {
+		return "error"
+	}
+	return strconv.Itoa(m.StatusCode/100) + "xx"
+}
+
+// Metrics returns the client's MetricsRecorder. Unless Config.Metrics was
+// set, it is a *PrometheusMetrics.
+func (c *Client) Metrics() MetricsRecorder {
+	return c.metrics
+}
+
+// metricsBody reports a finished attempt when the response body is closed,
+// counting the bytes read from it. wire counts the bytes beneath any
+// decompression.
+type metricsBody struct {
+	io.ReadCloser
+	recorder MetricsRecorder
+	metrics  RequestMetrics
+	wire     *byteCounter
+	once     sync.Once
+}
+
+func (b *metricsBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.metrics.UncompressedBytesReceived += int64(n)
+	return n, err
+}
+
+func (b *metricsBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.metrics.BytesReceived = b.wire.n
+		b.recorder.RequestFinished(b.metrics)
+	})
+	return err
+}
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the latency
+// histogram buckets PrometheusMetrics uses by default
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PrometheusMetrics is a MetricsRecorder that keeps its metrics in memory and
+// serves them in the Prometheus text format. Mount it on a metrics endpoint:
+//
+//	http.Handle("/metrics/control-client", client.Metrics().(*controlclient.PrometheusMetrics))
+type PrometheusMetrics struct {
+	namespace string
+	buckets   []float64
+
+	mu        sync.Mutex
+	latency   map[routeKey]*histogram
+	requests  map[statusKey]uint64
+	inFlight  map[routeKey]int64
+	retries   map[routeKey]uint64
+	bytesSent map[routeKey]uint64
+	bytesRecv map[routeKey]uint64
+	jsonSent  map[routeKey]uint64
+	jsonRecv  map[routeKey]uint64
+}
+
+type routeKey struct {
+	method string
+	route  string
+}
+
+type statusKey struct {
+	routeKey
+	class string
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewPrometheusMetrics creates a PrometheusMetrics. Metric names are prefixed
+// with namespace (default "control_client"). Buckets are the latency
+// histogram's upper bounds in seconds (default DefaultLatencyBuckets).
+func NewPrometheusMetrics(namespace string, buckets ...float64) *PrometheusMetrics {
+	if namespace == "" {
+		namespace = "control_client"
+	}
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &PrometheusMetrics{
+		namespace: namespace,
+		buckets:   buckets,
+		latency:   make(map[routeKey]*histogram),
+		requests:  make(map[statusKey]uint64),
+		inFlight:  make(map[routeKey]int64),
+		retries:   make(map[routeKey]uint64),
+		bytesSent: make(map[routeKey]uint64),
+		bytesRecv: make(map[routeKey]uint64),
+		jsonSent:  make(map[routeKey]uint64),
+		jsonRecv:  make(map[routeKey]uint64),
+	}
+}
+
+func (p *PrometheusMetrics) RequestStarted(method, route string) {
+	p.mu.Lock()
+	p.inFlight[routeKey{method, route}]++
+	p.mu.Unlock()
+}
+
+func (p *PrometheusMetrics) RequestFinished(m RequestMetrics) {
+	key := routeKey{m.Method, m.Route}
+	secs := m.Duration.Seconds()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.inFlight[key]--
+	p.requests[statusKey{key, m.StatusClass()}]++
+	p.bytesSent[key] += uint64(m.BytesSent)
+	p.bytesRecv[key] += uint64(m.BytesReceived)
+	p.jsonSent[key] += uint64(m.UncompressedBytesSent)
+	p.jsonRecv[key] += uint64(m.UncompressedBytesReceived)
+
+	h := p.latency[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(p.buckets)+1)}
+		p.latency[key] = h
+	}
+	i := sort.SearchFloat64s(p.buckets, secs)
+	h.counts[i]++
+	h.sum += secs
+	h.count++
+}
+
+func (p *PrometheusMetrics) RequestRetried(method, route string) {
+	p.mu.Lock()
+	p.retries[routeKey{method, route}]++
+	p.mu.Unlock()
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = p.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (p *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	p.mu.Lock()
+	p.writeHistogram(bw)
+	p.writeStatusCounter(bw)
+	p.writeRouteMetric(bw, "requests_in_flight", "gauge", "Requests sent and not yet finished.", int64Values(p.inFlight))
+	p.writeRouteMetric(bw, "retries_total", "counter", "Retries of failed requests.", uint64Values(p.retries))
+	p.writeRouteMetric(bw, "request_bytes_total", "counter", "Request body bytes sent.", uint64Values(p.bytesSent))
+	p.writeRouteMetric(bw, "response_bytes_total", "counter", "Response body bytes received.", uint64Values(p.bytesRecv))
+	p.writeRouteMetric(bw, "request_uncompressed_bytes_total", "counter", "Request body bytes before compression.", uint64Values(p.jsonSent))
+	p.writeRouteMetric(bw, "response_uncompressed_bytes_total", "counter", "Response body bytes after decompression.", uint64Values(p.jsonRecv))
+	p.mu.Unlock()
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (p *PrometheusMetrics) writeHistogram(w *bufio.Writer) {
+	name := p.namespace + "_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Time until response headers arrived.\n# TYPE %s histogram\n", name, name)
+	for _, key := range sortedRouteKeys(p.latency) {
+		h := p.latency[key]
+		labels := routeLabels(key)
+		var cumulative uint64
+		for i, le := range p.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+}
+
+func (p *PrometheusMetrics) writeStatusCounter(w *bufio.Writer) {
+	name := p.namespace + "_requests_total"
+	fmt.Fprintf(w, "# HELP %s Requests by status class.\n# TYPE %s counter\n", name, name)
+
+	keys := make([]statusKey, 0, len(p.requests))
+	for k := range p.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].routeKey != keys[j].routeKey {
+			return lessRouteKey(keys[i].routeKey, keys[j].routeKey)
+		}
+		return keys[i].class < keys[j].class
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s,status_class=\"%s\"} %d\n", name, routeLabels(k.routeKey), k.class, p.requests[k])
+	}
+}
+
+func (p *PrometheusMetrics) writeRouteMetric(w *bufio.Writer, suffix, typ, help string, values map[routeKey]string) {
+	name := p.namespace + "_" + suffix
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, key := range sortedRouteKeys(values) {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, routeLabels(key), values[key])
+	}
+}
+
+func int64Values(m map[routeKey]int64) map[routeKey]string {
+	out := make(map[routeKey]string, len(m))
+	for k, v := range m {
+		out[k] = strconv.FormatInt(v, 10)
+	}
+	return out
+}
+
+func uint64Values(m map[routeKey]uint64) map[routeKey]string {
+	out := make(map[routeKey]string, len(m))
+	for k, v := range m {
+		out[k] = strconv.FormatUint(v, 10)
+	}
+	return out
+}
+
+func sortedRouteKeys[V any](m map[routeKey]V) []routeKey {
+	keys := make([]routeKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return lessRouteKey(keys[i], keys[j]) })
+	return keys
+}
+
+func lessRouteKey(a, b routeKey) bool {
+	if a.route != b.route {
+		return a.route < b.route
+	}
+	return a.method < b.method
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func routeLabels(k routeKey) string {
+	return fmt.Sprintf("method=\"%s\",route=\"%s\"", labelEscaper.Replace(k.method), labelEscaper.Replace(k.route))
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,31 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"errors"
+)
+
+// Get methods return an error matching ErrNotFound when the resource doesn't
+// exist:
+//
+//	account, err := client.GetAccount(ctx, accountID)
+//	if errors.Is(err, controlclient.ErrNotFound) {
+//		// no such account
+//	}
+//
+// CheckTenantLock, CheckFileLock, GetPiperJob, GetCachedPipelineConfiguration,
+// GetMetadataGenerationStatus and GetParquetMetadataSummary return (nil, nil)
+// instead. Their Lookup variants return ErrNotFound like everything else.
+
+// IgnoreNotFound turns a not-found error from a getter into a nil result and
+// nil error, and passes anything else through:
+//
+//	account, err := controlclient.IgnoreNotFound(client.GetAccount(ctx, id))
+func IgnoreNotFound[T any](v *T, err error) (*T, error) {
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return v, err
+}
@@ -0,0 +1,37 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestNotFound(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	c := NewClient(Config{BaseURL: srv.URL})
+	ctx := context.Background()
+
+	// The legacy methods keep returning (nil, nil) on 404
+	if lock, err := c.CheckTenantLock(ctx, "ten"); lock != nil || err != nil {
+		t.Fatalf("expected nil and nil, got %v and %v", lock, err)
+	}
+	if job, err := c.GetPiperJob(ctx, "job"); job != nil || err != nil {
+		t.Fatalf("expected nil and nil, got %v and %v", job, err)
+	}
+
+	if _, err := c.LookupTenantLock(ctx, "ten"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := c.LookupPiperJob(ctx, "job"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := c.GetAccount(ctx, "acc"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
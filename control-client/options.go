@@ -0,0 +1,77 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import "net/http"
+
+// Option customizes a Client beyond what Config covers. Options are applied
+// in the order given to NewClient.
+type Option func(*Client)
+
+// Middleware wraps the transport, for example to add tracing or to record
+// requests in tests
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// WithHTTPClient uses a copy of hc for all requests, in place of the one built
+// from Config. Its Timeout is used as-is, so Config.TimeoutSeconds no longer
+// applies. If Config.TLS is set, hc's transport must be nil or an
+// *http.Transport, which is cloned and given the TLS configuration; any other
+// transport fails every request with an invalid TLS configuration error.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		cp := *hc
+		c.httpClient = &cp
+	}
+}
+
+// WithTransport sets the transport used to send requests, e.g. to add a proxy
+// or tune connection pooling. If Config.TLS is set, rt must be an
+// *http.Transport, which is cloned and given the TLS configuration; any other
+// transport fails every request with an invalid TLS configuration error. Use
+// WithMiddleware to wrap the transport instead.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) {
+		c.httpClient.Transport = rt
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request
+func WithUserAgent(userAgent string) Option {
+	return WithHeader("User-Agent", userAgent)
+}
+
+// WithHeader adds a header sent with every request. Headers set this way are
+// applied after the client's own, so they win.
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		if c.headers == nil {
+			c.headers = make(http.Header)
+		}
+		c.headers.Set(key, value)
+	}
+}
+
+// WithMiddleware wraps the transport with mw. Middleware added first sees
+// requests first.
+func WithMiddleware(mw Middleware) Option {
+	return func(c *Client) {
+		c.middleware = append(c.middleware, mw)
+	}
+}
+
+// applyMiddleware wraps the client's transport in its middleware, outermost
+// first
+func (c *Client) applyMiddleware() {
+	if len(c.middleware) == 0 {
+		return
+	}
+	rt := c.httpClient.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		rt = c.middleware[i](rt)
+	}
+	c.httpClient.Transport = rt
+}
@@ -0,0 +1,79 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTLSTestServer starts a TLS server that answers health checks, and
+// returns it with a TLSConfig that trusts it
+func newTLSTestServer(t *testing.T) (*httptest.Server, TLSConfig) {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	return srv, TLSConfig{CAPEM: ca}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTLSAppliedToSuppliedTransport(t *testing.T) {
+	srv, tlsConfig := newTLSTestServer(t)
+	supplied := &http.Transport{MaxIdleConnsPerHost: 7}
+
+	for name, opt := range map[string]Option{
+		"WithTransport":  WithTransport(supplied),
+		"WithHTTPClient": WithHTTPClient(&http.Client{Transport: supplied}),
+		"no transport":   WithHTTPClient(&http.Client{}),
+	} {
+		c := NewClient(Config{BaseURL: srv.URL, TLS: &tlsConfig}, opt)
+		if err := c.HealthCheck(context.Background()); err != nil {
+			t.Fatalf("%s: expected the TLS configuration to be used, got %v", name, err)
+		}
+		if name != "no transport" {
+			transport := c.httpClient.Transport.(*http.Transport)
+			if transport == supplied || transport.MaxIdleConnsPerHost != 7 {
+				t.Fatalf("%s: expected a configured clone of the supplied transport", name)
+			}
+		}
+	}
+}
+
+func TestTLSWithOtherRoundTripper(t *testing.T) {
+	srv, tlsConfig := newTLSTestServer(t)
+	rt := roundTripperFunc(http.DefaultTransport.RoundTrip)
+
+	c := NewClient(Config{BaseURL: srv.URL, TLS: &tlsConfig}, WithTransport(rt))
+	err := c.HealthCheck(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "invalid TLS configuration") {
+		t.Fatalf("expected an invalid TLS configuration error, got %v", err)
+	}
+
+	// Middleware wraps the configured transport rather than replacing it
+	var wrapped bool
+	c = NewClient(Config{BaseURL: srv.URL, TLS: &tlsConfig}, WithMiddleware(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			wrapped = true
+			return next.RoundTrip(req)
+		})
+	}))
+	if err := c.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !wrapped {
+		t.Fatal("expected the request to go through the middleware")
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"context"
 	"fmt"
 	"net/url"
-	"strconv"
 )
 
 // ====================================================================================
@@ -61,18 +60,20 @@ func (c *Client) UpdateTenantLockHeartbeat(ctx context.Context, tenantID, locked
 	return c.parseResponse(resp, nil)
 }
 
-// CheckTenantLock checks if a tenant is currently locked
+// CheckTenantLock checks if a tenant is currently locked. It returns nil if
+// the tenant isn't locked.
 func (c *Client) CheckTenantLock(ctx context.Context, tenantID string) (*PackerTenantLock, error) {
+	return IgnoreNotFound(c.LookupTenantLock(ctx, tenantID))
+}
+
+// LookupTenantLock is CheckTenantLock, but returns an error matching
+// ErrNotFound if the tenant isn't locked
+func (c *Client) LookupTenantLock(ctx context.Context, tenantID string) (*PackerTenantLock, error) {
 	path := fmt.Sprintf("/api/v1/packer/locks/tenants/%s", tenantID)
 	resp, err := c.doRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 404 {
-		return nil, nil // No lock exists
-	}
 
 	var lock PackerTenantLock
 	if err := c.parseResponse(resp, &lock); err != nil {
@@ -149,55 +150,40 @@ func (c *Client) UpsertParquetFileMetadata(ctx context.Context, metadata *Packer
 
 // GetParquetFileMetadataByPartition retrieves metadata for files in a specific partition
 func (c *Client) GetParquetFileMetadataByPartition(ctx context.Context, tenantID, datasetID, partitionPath string, limit int) ([]PackerParquetFileMetadata, error) {
-	path := fmt.Sprintf("/api/v1/packer/metadata/files/%s/%s", tenantID, datasetID)
+	return collectPage(c.GetParquetFileMetadataByPartitionPage(ctx, tenantID, datasetID, partitionPath, PageOptions{Limit: limit}))
+}
+
+// GetParquetFileMetadataByPartitionPage retrieves one page of the file metadata in a partition
+func (c *Client) GetParquetFileMetadataByPartitionPage(ctx context.Context, tenantID, datasetID, partitionPath string, opts PageOptions) (*Page[PackerParquetFileMetadata], error) {
 	params := url.Values{}
 	if partitionPath != "" {
 		params.Set("partition_path", partitionPath)
 	}
-	if limit > 0 {
-		params.Set("limit", strconv.Itoa(limit))
-	}
-	if len(params) > 0 {
-		path += "?" + params.Encode()
-	}
-
-	resp, err := c.doRequest(ctx, "GET", path, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	var response struct {
-		Items []PackerParquetFileMetadata `json:"items"`
-		Total int                         `json:"total"`
-	}
-	if err := c.parseResponse(resp, &response); err != nil {
-		return nil, err
-	}
+	return getPage[PackerParquetFileMetadata](ctx, c, fmt.Sprintf("/api/v1/packer/metadata/files/%s/%s", tenantID, datasetID), params, opts)
+}
 
-	return response.Items, nil
+// IterateParquetFileMetadataByPartition walks all of the file metadata in a partition, opts.Limit at a time
+func (c *Client) IterateParquetFileMetadataByPartition(ctx context.Context, tenantID, datasetID, partitionPath string, opts PageOptions) *Iterator[PackerParquetFileMetadata] {
+	return newIterator(ctx, opts, func(ctx context.Context, opts PageOptions) (*Page[PackerParquetFileMetadata], error) {
+		return c.GetParquetFileMetadataByPartitionPage(ctx, tenantID, datasetID, partitionPath, opts)
+	})
 }
 
 // GetAllParquetFileMetadata retrieves all metadata for a tenant/dataset
 func (c *Client) GetAllParquetFileMetadata(ctx context.Context, tenantID, datasetID string, limit int) ([]PackerParquetFileMetadata, error) {
-	path := fmt.Sprintf("/api/v1/packer/metadata/files/%s/%s/all", tenantID, datasetID)
-	if limit > 0 {
-		path += fmt.Sprintf("?limit=%d", limit)
-	}
-
-	resp, err := c.doRequest(ctx, "GET", path, nil)
-	if err != nil {
-		return nil, err
-	}
+	return collectPage(c.GetAllParquetFileMetadataPage(ctx, tenantID, datasetID, PageOptions{Limit: limit}))
+}
 
-	var response struct {
-		Items []PackerParquetFileMetadata `json:"items"`
-		Total int                         `json:"total"`
-	}
-	if err := c.parseResponse(resp, &response); err != nil {
-		return nil, err
-	}
+// GetAllParquetFileMetadataPage retrieves one page of a tenant/dataset's file metadata
+func (c *Client) GetAllParquetFileMetadataPage(ctx context.Context, tenantID, datasetID string, opts PageOptions) (*Page[PackerParquetFileMetadata], error) {
+	return getPage[PackerParquetFileMetadata](ctx, c, fmt.Sprintf("/api/v1/packer/metadata/files/%s/%s/all", tenantID, datasetID), nil, opts)
+}
 
-	return response.Items, nil
+// IterateAllParquetFileMetadata walks all of a tenant/dataset's file metadata, opts.Limit at a time
+func (c *Client) IterateAllParquetFileMetadata(ctx context.Context, tenantID, datasetID string, opts PageOptions) *Iterator[PackerParquetFileMetadata] {
+	return newIterator(ctx, opts, func(ctx context.Context, opts PageOptions) (*Page[PackerParquetFileMetadata], error) {
+		return c.GetAllParquetFileMetadataPage(ctx, tenantID, datasetID, opts)
+	})
 }
 
 // DeleteParquetFileMetadata deletes metadata for a specific file or partition
@@ -264,18 +250,20 @@ func (c *Client) UpdateMetadataGenerationStatus(ctx context.Context, status *Pac
 	return c.parseResponse(resp, nil)
 }
 
-// GetMetadataGenerationStatus retrieves the metadata generation status for a partition
+// GetMetadataGenerationStatus retrieves the metadata generation status for a partition.
+// It returns nil if there is none.
 func (c *Client) GetMetadataGenerationStatus(ctx context.Context, tenantID, datasetID, partitionPath string) (*PackerMetadataGenerationStatus, error) {
+	return IgnoreNotFound(c.LookupMetadataGenerationStatus(ctx, tenantID, datasetID, partitionPath))
+}
+
+// LookupMetadataGenerationStatus is GetMetadataGenerationStatus, but returns
+// an error matching ErrNotFound if there is none
+func (c *Client) LookupMetadataGenerationStatus(ctx context.Context, tenantID, datasetID, partitionPath string) (*PackerMetadataGenerationStatus, error) {
 	path := fmt.Sprintf("/api/v1/packer/metadata/generation/status/%s/%s/%s", tenantID, datasetID, url.PathEscape(partitionPath))
 	resp, err := c.doRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 404 {
-		return nil, nil
-	}
 
 	var status PackerMetadataGenerationStatus
 	if err := c.parseResponse(resp, &status); err != nil {
@@ -289,18 +277,20 @@ func (c *Client) GetMetadataGenerationStatus(ctx context.Context, tenantID, data
 // PACKER METADATA SUMMARY OPERATIONS
 // ====================================================================================
 
-// GetParquetMetadataSummary retrieves aggregated metadata summary for a partition
+// GetParquetMetadataSummary retrieves aggregated metadata summary for a partition.
+// It returns nil if there is none.
 func (c *Client) GetParquetMetadataSummary(ctx context.Context, tenantID, datasetID, partitionPath string) (*PackerParquetMetadataSummary, error) {
+	return IgnoreNotFound(c.LookupParquetMetadataSummary(ctx, tenantID, datasetID, partitionPath))
+}
+
+// LookupParquetMetadataSummary is GetParquetMetadataSummary, but returns an
+// error matching ErrNotFound if there is none
+func (c *Client) LookupParquetMetadataSummary(ctx context.Context, tenantID, datasetID, partitionPath string) (*PackerParquetMetadataSummary, error) {
 	path := fmt.Sprintf("/api/v1/packer/metadata/summary/%s/%s/%s", tenantID, datasetID, url.PathEscape(partitionPath))
 	resp, err := c.doRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 404 {
-		return nil, nil
-	}
 
 	var summary PackerParquetMetadataSummary
 	if err := c.parseResponse(resp, &summary); err != nil {
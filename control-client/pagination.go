@@ -0,0 +1,221 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PageOptions selects one page of a list
+type PageOptions struct {
+	// Limit is the page size. Zero uses the server's default; an iterator
+	// over a server that returns neither a total nor a next cursor then
+	// can't tell a full page from the last, and stops after the first.
+	Limit int
+	// Offset skips that many items. It is ignored when Cursor is set.
+	Offset int
+	// Cursor is a previous page's NextCursor
+	Cursor string
+}
+
+// Page is one page of a list
+type Page[T any] struct {
+	Items []T `json:"items"`
+	// Total is the number of items in the whole list, if the server reports it
+	Total int `json:"total"`
+	// NextCursor fetches the following page when passed as PageOptions.Cursor.
+	// It is empty on the last page.
+	NextCursor string `json:"next_cursor"`
+}
+
+// listAllPageSize is the page size used when the client lists everything
+// itself, as for ListInstances and TakeSnapshot. An explicit size lets it
+// keep paging on servers that report neither a total nor a next cursor.
+const listAllPageSize = 500
+
+// offsetCursorPrefix marks cursors the client made up for servers that page
+// by offset and don't return a cursor of their own
+const offsetCursorPrefix = "offset:"
+
+// query adds the page selection to params
+func (o PageOptions) query(params url.Values) {
+	if o.Limit > 0 {
+		params.Set("limit", strconv.Itoa(o.Limit))
+	}
+	offset := o.Offset
+	if o.Cursor != "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(o.Cursor, offsetCursorPrefix))
+		if !strings.HasPrefix(o.Cursor, offsetCursorPrefix) || err != nil {
+			params.Set("cursor", o.Cursor)
+			return
+		}
+		offset = n
+	}
+	if offset > 0 {
+		params.Set("offset", strconv.Itoa(offset))
+	}
+}
+
+// getPage fetches a page of a list from path
+func getPage[T any](ctx context.Context, c *Client, path string, params url.Values, opts PageOptions) (*Page[T], error) {
+	if params == nil {
+		params = url.Values{}
+	}
+	opts.query(params)
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+
+	resp, err := c.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var page Page[T]
+	if err := c.parseResponse(resp, &page); err != nil {
+		return nil, err
+	}
+	page.fillNextCursor(opts, len(page.Items))
+
+	return &page, nil
+}
+
+// fillNextCursor makes up an offset cursor when the server pages by offset,
+// so callers can page the same way either way. n is the number of items on
+// the page.
+func (p *Page[T]) fillNextCursor(opts PageOptions, n int) {
+	if p.NextCursor != "" || n == 0 {
+		return
+	}
+	if opts.Cursor != "" && !strings.HasPrefix(opts.Cursor, offsetCursorPrefix) {
+		// The server pages by cursor and this was the last page
+		return
+	}
+
+	offset := opts.Offset
+	if opts.Cursor != "" {
+		offset, _ = strconv.Atoi(strings.TrimPrefix(opts.Cursor, offsetCursorPrefix))
+	}
+	next := offset + n
+
+	switch {
+	case p.Total > 0 && next >= p.Total:
+		return
+	case p.Total == 0 && (opts.Limit <= 0 || n < opts.Limit):
+		// Without a total, a short page is the last one
+		return
+	}
+	p.NextCursor = offsetCursorPrefix + strconv.Itoa(next)
+}
+
+// Iterator walks all pages of a list, fetching the next page in the
+// background while the current one is consumed:
+//
+//	it := client.IterateAccounts(ctx, controlclient.PageOptions{Limit: 100})
+//	defer it.Close()
+//	for it.Next() {
+//		account := it.Item()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator[T any] struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	fetch  func(ctx context.Context, opts PageOptions) (*Page[T], error)
+	opts   PageOptions
+
+	pending chan pageResult[T]
+	items   []T
+	pos     int
+	total   int
+	err     error
+}
+
+type pageResult[T any] struct {
+	page *Page[T]
+	err  error
+}
+
+func newIterator[T any](ctx context.Context, opts PageOptions, fetch func(ctx context.Context, opts PageOptions) (*Page[T], error)) *Iterator[T] {
+	ctx, cancel := context.WithCancel(ctx)
+	it := &Iterator[T]{ctx: ctx, cancel: cancel, fetch: fetch, opts: opts, pos: -1}
+	it.prefetch(opts)
+	return it
+}
+
+// prefetch starts fetching the page opts selects
+func (it *Iterator[T]) prefetch(opts PageOptions) {
+	// Buffered so the fetch can finish even if the iterator is abandoned
+	ch := make(chan pageResult[T], 1)
+	it.pending = ch
+	go func() {
+		page, err := it.fetch(it.ctx, opts)
+		ch <- pageResult[T]{page: page, err: err}
+	}()
+}
+
+// Next advances to the next item, fetching pages as needed. It returns false
+// when the list is exhausted or a fetch failed; check Err to tell which.
+func (it *Iterator[T]) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	it.pos++
+	for it.pos >= len(it.items) {
+		if it.pending == nil {
+			it.cancel()
+			return false
+		}
+		res := <-it.pending
+		it.pending = nil
+		if res.err != nil {
+			it.err = res.err
+			it.items = nil
+			it.cancel()
+			return false
+		}
+
+		it.items, it.pos, it.total = res.page.Items, 0, res.page.Total
+		if res.page.NextCursor != "" {
+			next := it.opts
+			next.Cursor = res.page.NextCursor
+			it.prefetch(next)
+		}
+	}
+	return true
+}
+
+// Item returns the current item. Call it only after Next returned true.
+func (it *Iterator[T]) Item() T {
+	return it.items[it.pos]
+}
+
+// Total returns the list's total size as reported with the latest page
+func (it *Iterator[T]) Total() int {
+	return it.total
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Close stops any fetch in progress. Call it when stopping early.
+func (it *Iterator[T]) Close() {
+	it.cancel()
+}
+
+// collectPage returns a page's items, the behavior of the original
+// limit-only list methods
+func collectPage[T any](page *Page[T], err error) ([]T, error) {
+	if err != nil {
+		return nil, err
+	}
+	return page.Items, nil
+}
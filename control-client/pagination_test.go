@@ -0,0 +1,140 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// accountPages serves n accounts by offset, limit at a time. With withTotal
+// the total is reported; failOn, if not zero, is a request that gets a 500.
+func accountPages(t *testing.T, n int, withTotal bool, failOn int) *testServer {
+	return newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		if call == failOn {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		var items []string
+		for i := offset; i < n && i < offset+limit; i++ {
+			items = append(items, fmt.Sprintf(`{"id": "acc%d"}`, i))
+		}
+		total := 0
+		if withTotal {
+			total = n
+		}
+		fmt.Fprintf(w, `{"items": [%s], "total": %d}`, strings.Join(items, ", "), total)
+	})
+}
+
+// collectIDs walks it to the end
+func collectIDs(it *Iterator[Account]) ([]string, error) {
+	defer it.Close()
+	var ids []string
+	for it.Next() {
+		ids = append(ids, it.Item().ID)
+	}
+	return ids, it.Err()
+}
+
+func TestIteratorCursorPages(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			w.Write([]byte(`{"items": [{"id": "a"}, {"id": "b"}], "next_cursor": "c2"}`))
+		case "c2":
+			w.Write([]byte(`{"items": [{"id": "c"}, {"id": "d"}], "next_cursor": "c4"}`))
+		case "c4":
+			w.Write([]byte(`{"items": [{"id": "e"}]}`))
+		default:
+			t.Errorf("unexpected cursor %q", r.URL.Query().Get("cursor"))
+		}
+	})
+	c := NewClient(Config{BaseURL: srv.URL})
+
+	ids, err := collectIDs(c.IterateAccounts(context.Background(), PageOptions{Limit: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ids, ",") != "a,b,c,d,e" || srv.Calls() != 3 {
+		t.Fatalf("expected a to e over 3 pages, got %v over %d", ids, srv.Calls())
+	}
+}
+
+func TestIteratorOffsetPages(t *testing.T) {
+	srv := accountPages(t, 5, true, 0)
+	c := NewClient(Config{BaseURL: srv.URL})
+
+	it := c.IterateAccounts(context.Background(), PageOptions{Limit: 2})
+	ids, err := collectIDs(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 5 || ids[4] != "acc4" || it.Total() != 5 {
+		t.Fatalf("expected 5 accounts in order with a total of 5, got %v and %d", ids, it.Total())
+	}
+	// The total says the third page is the last, so no fourth is asked for
+	if srv.Calls() != 3 {
+		t.Fatalf("expected 3 pages, got %d", srv.Calls())
+	}
+}
+
+func TestIteratorEmptyLastPage(t *testing.T) {
+	// Without a total, a full last page looks like there may be more
+	srv := accountPages(t, 4, false, 0)
+	c := NewClient(Config{BaseURL: srv.URL})
+
+	ids, err := collectIDs(c.IterateAccounts(context.Background(), PageOptions{Limit: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 4 || srv.Calls() != 3 {
+		t.Fatalf("expected 4 accounts and an empty third page, got %v over %d pages", ids, srv.Calls())
+	}
+}
+
+func TestIteratorStopEarly(t *testing.T) {
+	srv := accountPages(t, 100, true, 0)
+	c := NewClient(Config{BaseURL: srv.URL})
+
+	it := c.IterateAccounts(context.Background(), PageOptions{Limit: 2})
+	for it.Next() {
+		if it.Item().ID == "acc0" {
+			break
+		}
+	}
+	it.Close()
+	if it.Err() != nil {
+		t.Fatalf("expected no error after stopping early, got %v", it.Err())
+	}
+
+	// At most the page after the first was prefetched
+	time.Sleep(20 * time.Millisecond)
+	if srv.Calls() > 2 {
+		t.Fatalf("expected no pages fetched after Close, got %d", srv.Calls())
+	}
+}
+
+func TestIteratorPageError(t *testing.T) {
+	srv := accountPages(t, 10, true, 3)
+	c := NewClient(Config{BaseURL: srv.URL, Retry: RetryPolicy{MaxAttempts: 1}})
+
+	ids, err := collectIDs(c.IterateAccounts(context.Background(), PageOptions{Limit: 2}))
+	if err == nil || !strings.Contains(err.Error(), "status 500") {
+		t.Fatalf("expected the third page's error, got %v", err)
+	}
+	if len(ids) != 4 {
+		t.Fatalf("expected the first two pages' items before the error, got %v", ids)
+	}
+	if srv.Calls() != 3 {
+		t.Fatalf("expected no pages after the failed one, got %d requests", srv.Calls())
+	}
+}
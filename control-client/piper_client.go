@@ -7,7 +7,6 @@ import (
 	"context"
 	"fmt"
 	"net/url"
-	"strconv"
 
 	"github.com/bytedance/sonic"
 )
@@ -61,18 +60,20 @@ func (c *Client) ReleaseFileLock(ctx context.Context, tenantID, datasetID, fileK
 	return c.parseResponse(resp, nil)
 }
 
-// CheckFileLock checks if a file is currently locked
+// CheckFileLock checks if a file is currently locked. It returns nil if the
+// file isn't locked.
 func (c *Client) CheckFileLock(ctx context.Context, tenantID, datasetID, fileKey string) (*PiperFileLock, error) {
+	return IgnoreNotFound(c.LookupFileLock(ctx, tenantID, datasetID, fileKey))
+}
+
+// LookupFileLock is CheckFileLock, but returns an error matching ErrNotFound
+// if the file isn't locked
+func (c *Client) LookupFileLock(ctx context.Context, tenantID, datasetID, fileKey string) (*PiperFileLock, error) {
 	path := fmt.Sprintf("/api/v1/piper/locks/files/%s/%s/%s", tenantID, datasetID, url.PathEscape(fileKey))
 	resp, err := c.doRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 404 {
-		return nil, nil // No lock exists
-	}
 
 	var lock PiperFileLock
 	if err := c.parseResponse(resp, &lock); err != nil {
@@ -150,18 +151,19 @@ func (c *Client) UpdatePiperJobStatus(ctx context.Context, jobID, status, errorM
 	return c.parseResponse(resp, nil)
 }
 
-// GetPiperJob retrieves a job by ID
+// GetPiperJob retrieves a job by ID. It returns nil if there is no such job.
 func (c *Client) GetPiperJob(ctx context.Context, jobID string) (*PiperJobRecord, error) {
+	return IgnoreNotFound(c.LookupPiperJob(ctx, jobID))
+}
+
+// LookupPiperJob is GetPiperJob, but returns an error matching ErrNotFound if
+// there is no such job
+func (c *Client) LookupPiperJob(ctx context.Context, jobID string) (*PiperJobRecord, error) {
 	path := fmt.Sprintf("/api/v1/piper/jobs/%s", jobID)
 	resp, err := c.doRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 404 {
-		return nil, nil
-	}
 
 	var job PiperJobRecord
 	if err := c.parseResponse(resp, &job); err != nil {
@@ -173,55 +175,40 @@ func (c *Client) GetPiperJob(ctx context.Context, jobID string) (*PiperJobRecord
 
 // GetPiperJobsByStatus retrieves jobs by status
 func (c *Client) GetPiperJobsByStatus(ctx context.Context, status string, limit int) ([]PiperJobRecord, error) {
-	path := "/api/v1/piper/jobs"
+	return collectPage(c.GetPiperJobsByStatusPage(ctx, status, PageOptions{Limit: limit}))
+}
+
+// GetPiperJobsByStatusPage retrieves one page of the jobs with a status
+func (c *Client) GetPiperJobsByStatusPage(ctx context.Context, status string, opts PageOptions) (*Page[PiperJobRecord], error) {
 	params := url.Values{}
 	if status != "" {
 		params.Set("status", status)
 	}
-	if limit > 0 {
-		params.Set("limit", strconv.Itoa(limit))
-	}
-	if len(params) > 0 {
-		path += "?" + params.Encode()
-	}
-
-	resp, err := c.doRequest(ctx, "GET", path, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	var response struct {
-		Items []PiperJobRecord `json:"items"`
-		Total int              `json:"total"`
-	}
-	if err := c.parseResponse(resp, &response); err != nil {
-		return nil, err
-	}
+	return getPage[PiperJobRecord](ctx, c, "/api/v1/piper/jobs", params, opts)
+}
 
-	return response.Items, nil
+// IteratePiperJobsByStatus walks all of the jobs with a status, opts.Limit at a time
+func (c *Client) IteratePiperJobsByStatus(ctx context.Context, status string, opts PageOptions) *Iterator[PiperJobRecord] {
+	return newIterator(ctx, opts, func(ctx context.Context, opts PageOptions) (*Page[PiperJobRecord], error) {
+		return c.GetPiperJobsByStatusPage(ctx, status, opts)
+	})
 }
 
 // GetPiperJobsForTenant retrieves all jobs for a tenant
 func (c *Client) GetPiperJobsForTenant(ctx context.Context, tenantID string, limit int) ([]PiperJobRecord, error) {
-	path := fmt.Sprintf("/api/v1/piper/jobs/tenant/%s", tenantID)
-	if limit > 0 {
-		path += fmt.Sprintf("?limit=%d", limit)
-	}
-
-	resp, err := c.doRequest(ctx, "GET", path, nil)
-	if err != nil {
-		return nil, err
-	}
+	return collectPage(c.GetPiperJobsForTenantPage(ctx, tenantID, PageOptions{Limit: limit}))
+}
 
-	var response struct {
-		Items []PiperJobRecord `json:"items"`
-		Total int              `json:"total"`
-	}
-	if err := c.parseResponse(resp, &response); err != nil {
-		return nil, err
-	}
+// GetPiperJobsForTenantPage retrieves one page of a tenant's jobs
+func (c *Client) GetPiperJobsForTenantPage(ctx context.Context, tenantID string, opts PageOptions) (*Page[PiperJobRecord], error) {
+	return getPage[PiperJobRecord](ctx, c, fmt.Sprintf("/api/v1/piper/jobs/tenant/%s", tenantID), nil, opts)
+}
 
-	return response.Items, nil
+// IteratePiperJobsForTenant walks all of a tenant's jobs, opts.Limit at a time
+func (c *Client) IteratePiperJobsForTenant(ctx context.Context, tenantID string, opts PageOptions) *Iterator[PiperJobRecord] {
+	return newIterator(ctx, opts, func(ctx context.Context, opts PageOptions) (*Page[PiperJobRecord], error) {
+		return c.GetPiperJobsForTenantPage(ctx, tenantID, opts)
+	})
 }
 
 // CleanupOldPiperJobs deletes jobs older than specified days
@@ -261,18 +248,20 @@ func (c *Client) CachePipelineConfiguration(ctx context.Context, tenantID, datas
 	return c.parseResponse(resp, nil)
 }
 
-// GetCachedPipelineConfiguration retrieves a cached pipeline configuration
+// GetCachedPipelineConfiguration retrieves a cached pipeline configuration. It
+// returns nil if none is cached.
 func (c *Client) GetCachedPipelineConfiguration(ctx context.Context, tenantID, datasetID string) (*PiperPipelineConfiguration, error) {
+	return IgnoreNotFound(c.LookupCachedPipelineConfiguration(ctx, tenantID, datasetID))
+}
+
+// LookupCachedPipelineConfiguration is GetCachedPipelineConfiguration, but
+// returns an error matching ErrNotFound if none is cached
+func (c *Client) LookupCachedPipelineConfiguration(ctx context.Context, tenantID, datasetID string) (*PiperPipelineConfiguration, error) {
 	path := fmt.Sprintf("/api/v1/piper/cache/pipelines/%s/%s", tenantID, datasetID)
 	resp, err := c.doRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 404 {
-		return nil, nil
-	}
 
 	var config PiperPipelineConfiguration
 	if err := c.parseResponse(resp, &config); err != nil {
@@ -295,25 +284,19 @@ func (c *Client) InvalidatePipelineConfiguration(ctx context.Context, tenantID,
 
 // ListCachedPipelines lists all cached pipeline configurations
 func (c *Client) ListCachedPipelines(ctx context.Context, limit int) ([]PiperPipelineConfiguration, error) {
-	path := "/api/v1/piper/cache/pipelines"
-	if limit > 0 {
-		path += fmt.Sprintf("?limit=%d", limit)
-	}
-
-	resp, err := c.doRequest(ctx, "GET", path, nil)
-	if err != nil {
-		return nil, err
-	}
+	return collectPage(c.ListCachedPipelinesPage(ctx, PageOptions{Limit: limit}))
+}
 
-	var response struct {
-		Items []PiperPipelineConfiguration `json:"items"`
-		Total int                          `json:"total"`
-	}
-	if err := c.parseResponse(resp, &response); err != nil {
-		return nil, err
-	}
+// ListCachedPipelinesPage retrieves one page of the cached pipeline configurations
+func (c *Client) ListCachedPipelinesPage(ctx context.Context, opts PageOptions) (*Page[PiperPipelineConfiguration], error) {
+	return getPage[PiperPipelineConfiguration](ctx, c, "/api/v1/piper/cache/pipelines", nil, opts)
+}
 
-	return response.Items, nil
+// IterateCachedPipelines walks all of the cached pipeline configurations, opts.Limit at a time
+func (c *Client) IterateCachedPipelines(ctx context.Context, opts PageOptions) *Iterator[PiperPipelineConfiguration] {
+	return newIterator(ctx, opts, func(ctx context.Context, opts PageOptions) (*Page[PiperPipelineConfiguration], error) {
+		return c.ListCachedPipelinesPage(ctx, opts)
+	})
 }
 
 // CleanupExpiredPipelineCache removes expired pipeline cache entries
@@ -353,25 +336,19 @@ func (c *Client) CacheTenant(ctx context.Context, tenantID string, tenantData ma
 
 // GetCachedTenants retrieves all cached tenants
 func (c *Client) GetCachedTenants(ctx context.Context, limit int) ([]PiperTenantCache, error) {
-	path := "/api/v1/piper/cache/tenants"
-	if limit > 0 {
-		path += fmt.Sprintf("?limit=%d", limit)
-	}
-
-	resp, err := c.doRequest(ctx, "GET", path, nil)
-	if err != nil {
-		return nil, err
-	}
+	return collectPage(c.GetCachedTenantsPage(ctx, PageOptions{Limit: limit}))
+}
 
-	var response struct {
-		Items []PiperTenantCache `json:"items"`
-		Total int                `json:"total"`
-	}
-	if err := c.parseResponse(resp, &response); err != nil {
-		return nil, err
-	}
+// GetCachedTenantsPage retrieves one page of the cached tenants
+func (c *Client) GetCachedTenantsPage(ctx context.Context, opts PageOptions) (*Page[PiperTenantCache], error) {
+	return getPage[PiperTenantCache](ctx, c, "/api/v1/piper/cache/tenants", nil, opts)
+}
 
-	return response.Items, nil
+// IterateCachedTenants walks all of the cached tenants, opts.Limit at a time
+func (c *Client) IterateCachedTenants(ctx context.Context, opts PageOptions) *Iterator[PiperTenantCache] {
+	return newIterator(ctx, opts, func(ctx context.Context, opts PageOptions) (*Page[PiperTenantCache], error) {
+		return c.GetCachedTenantsPage(ctx, opts)
+	})
 }
 
 // InvalidateTenantCache removes cached tenant(s)
@@ -0,0 +1,299 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testServer is an httptest server that counts the requests it gets
+type testServer struct {
+	*httptest.Server
+	calls int32
+}
+
+// newTestServer starts a server that answers with handler, which is given the
+// 1-based number of the request
+func newTestServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, call int)) *testServer {
+	t.Helper()
+	ts := &testServer{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, int(atomic.AddInt32(&ts.calls, 1)))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func (ts *testServer) Calls() int {
+	return int(atomic.LoadInt32(&ts.calls))
+}
+
+// fastRetries retries quickly, so tests don't wait on backoff
+var fastRetries = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestRetryBackoffBounds(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}.withDefaults()
+	ceilings := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, ceiling := range ceilings {
+		attempt := i + 1
+		ceiling *= time.Millisecond
+		seen := make(map[time.Duration]bool)
+		for n := 0; n < 200; n++ {
+			d := p.backoff(attempt)
+			if d < 0 || d > ceiling {
+				t.Fatalf("attempt %d: expected a delay in [0, %v], got %v", attempt, ceiling, d)
+			}
+			seen[d] = true
+		}
+		if len(seen) < 2 {
+			t.Fatalf("attempt %d: expected jittered delays, got the same one every time", attempt)
+		}
+	}
+
+	// A large attempt number must not overflow the shift
+	if d := p.backoff(100); d < 0 || d > time.Second {
+		t.Fatalf("expected a delay in [0, 1s], got %v", d)
+	}
+}
+
+func TestRetryServerErrors(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		if call < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	c := NewClient(Config{BaseURL: srv.URL, Retry: fastRetries})
+
+	if err := c.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Calls() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", srv.Calls())
+	}
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	c := NewClient(Config{BaseURL: srv.URL, Retry: fastRetries})
+
+	err := c.HealthCheck(context.Background())
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if srv.Calls() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", srv.Calls())
+	}
+}
+
+func TestRetryClientErrorsNotRetried(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict} {
+		srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+			w.WriteHeader(status)
+		})
+		c := NewClient(Config{BaseURL: srv.URL, Retry: fastRetries})
+
+		if _, err := c.GetAccount(context.Background(), "acc"); err == nil {
+			t.Fatalf("status %d: expected an error", status)
+		}
+		if srv.Calls() != 1 {
+			t.Fatalf("status %d: expected 1 attempt, got %d", status, srv.Calls())
+		}
+	}
+}
+
+func TestRetryTooManyRequests(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		if call == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	c := NewClient(Config{BaseURL: srv.URL, Retry: fastRetries})
+
+	if err := c.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Calls() != 2 {
+		t.Fatalf("expected 2 attempts, got %d", srv.Calls())
+	}
+}
+
+func TestRetryNonIdempotentMethods(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	c := NewClient(Config{BaseURL: srv.URL, Retry: fastRetries})
+
+	resp, err := c.doRequest(context.Background(), http.MethodPost, "/api/v1/accounts", map[string]string{"name": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if srv.Calls() != 1 {
+		t.Fatalf("expected a POST to be sent once, got %d attempts", srv.Calls())
+	}
+
+	// Opting in makes it retryable
+	ctx := WithCallOptions(context.Background(), RetryNonIdempotent())
+	resp, err = c.doRequest(ctx, http.MethodPost, "/api/v1/accounts", map[string]string{"name": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if srv.Calls() != 4 {
+		t.Fatalf("expected 3 more attempts with RetryNonIdempotent, got %d", srv.Calls()-1)
+	}
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		if call == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	c := NewClient(Config{BaseURL: srv.URL, Retry: RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: 5 * time.Second}})
+
+	start := time.Now()
+	if err := c.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("expected the retry to wait for Retry-After, it came after %v", elapsed)
+	}
+	if srv.Calls() != 2 {
+		t.Fatalf("expected 2 attempts, got %d", srv.Calls())
+	}
+}
+
+func TestRetryAfterBeyondMaxDelay(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	c := NewClient(Config{BaseURL: srv.URL, Retry: fastRetries})
+
+	start := time.Now()
+	resp, err := c.doRequest(context.Background(), http.MethodGet, "/api/v1/accounts/acc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected the 429 to be returned, got %d", resp.StatusCode)
+	}
+	if srv.Calls() != 1 || time.Since(start) > time.Second {
+		t.Fatalf("expected no retry, got %d attempts in %v", srv.Calls(), time.Since(start))
+	}
+}
+
+func TestRetryContextCancelledDuringBackoff(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	c := NewClient(Config{BaseURL: srv.URL, Retry: RetryPolicy{MaxDelay: 10 * time.Second}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := c.HealthCheck(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the backoff to end with the context, it took %v", elapsed)
+	}
+	if srv.Calls() != 1 {
+		t.Fatalf("expected 1 attempt, got %d", srv.Calls())
+	}
+}
+
+// countingCredentials fails every Token call and counts them
+type countingCredentials struct {
+	calls int32
+}
+
+func (c *countingCredentials) Token(context.Context) (string, error) {
+	atomic.AddInt32(&c.calls, 1)
+	return "", errors.New("token endpoint unavailable")
+}
+
+func (c *countingCredentials) Refresh(context.Context, string) error {
+	return nil
+}
+
+// retryCounter counts retries and ignores the other measurements
+type retryCounter struct {
+	retries int32
+}
+
+func (r *retryCounter) RequestStarted(method, route string) {}
+func (r *retryCounter) RequestFinished(m RequestMetrics)    {}
+func (r *retryCounter) RequestRetried(method, route string) { atomic.AddInt32(&r.retries, 1) }
+
+func TestRetryCredentialErrorsNotRetried(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusOK)
+	})
+	creds := &countingCredentials{}
+	c := NewClient(Config{
+		BaseURL:     srv.URL,
+		Retry:       fastRetries,
+		Credentials: creds,
+		Failover:    &FailoverConfig{ProbeInterval: time.Hour},
+	})
+	t.Cleanup(c.Close)
+
+	err := c.HealthCheck(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "failed to get credentials: token endpoint unavailable") {
+		t.Fatalf("expected the credentials error, got %v", err)
+	}
+	if n := atomic.LoadInt32(&creds.calls); n != 1 {
+		t.Fatalf("expected 1 attempt, got %d Token calls", n)
+	}
+	if srv.Calls() != 0 {
+		t.Fatalf("expected nothing sent without a token, got %d requests", srv.Calls())
+	}
+	// The token failure says nothing about the endpoint
+	if s := c.Endpoints()[0]; !s.Healthy {
+		t.Fatalf("expected the endpoint to stay healthy, got %+v", s)
+	}
+}
+
+func TestRetryConfigErrorsNotRetried(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for name, config := range map[string]Config{
+		"compression": {Compression: &CompressionConfig{Encoding: "brotli"}},
+		"TLS":         {TLS: &TLSConfig{CAPEM: []byte("not PEM")}},
+	} {
+		metrics := &retryCounter{}
+		config.BaseURL, config.Retry, config.Metrics = srv.URL, fastRetries, metrics
+		c := NewClient(config)
+
+		if err := c.HealthCheck(context.Background()); err == nil || !strings.Contains(err.Error(), "invalid "+name+" configuration") {
+			t.Fatalf("%s: expected an invalid configuration error, got %v", name, err)
+		}
+		if n := atomic.LoadInt32(&metrics.retries); n != 0 {
+			t.Fatalf("%s: expected no retries, got %d", name, n)
+		}
+	}
+}
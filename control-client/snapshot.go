@@ -0,0 +1,235 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrSnapshotInvalid is returned when a snapshot file can't be used: it is
+// truncated, fails its checksum, or has an unsupported version
+var ErrSnapshotInvalid = errors.New("invalid snapshot")
+
+// snapshotVersion is the snapshot file format written by WriteSnapshot
+const snapshotVersion = 1
+
+// Snapshot is the control-plane state a service needs to start, saved so it
+// can start when the Control Service is unreachable
+type Snapshot struct {
+	TakenAt   time.Time                    `json:"taken_at"`
+	Accounts  []Account                    `json:"accounts"`
+	Tenants   []Tenant                     `json:"tenants"`
+	Datasets  []Dataset                    `json:"datasets"`
+	Pipelines []PiperPipelineConfiguration `json:"pipelines"`
+	// FromFile is set on a snapshot loaded from disk rather than fetched
+	FromFile bool `json:"-"`
+}
+
+// snapshotFile is the on-disk form of a Snapshot. Checksum covers the exact
+// bytes of Snapshot.
+//
+// Unlike the rest of the client, snapshots are encoded with encoding/json
+// rather than sonic. json.RawMessage is written out byte for byte, so the
+// bytes LoadSnapshot checks are the ones WriteSnapshot summed, and the file
+// stays readable whichever JSON library a later version links in.
+type snapshotFile struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	Snapshot json.RawMessage `json:"snapshot"`
+}
+
+// Age returns how long ago the snapshot was taken
+func (s *Snapshot) Age() time.Duration {
+	return time.Since(s.TakenAt)
+}
+
+// TenantDatasets returns the snapshot's datasets for a tenant
+func (s *Snapshot) TenantDatasets(tenantID string) []Dataset {
+	var datasets []Dataset
+	for _, d := range s.Datasets {
+		if d.TenantID == tenantID {
+			datasets = append(datasets, d)
+		}
+	}
+	return datasets
+}
+
+// Pipeline returns the snapshot's pipeline configuration for a dataset, or nil
+// if it has none
+func (s *Snapshot) Pipeline(tenantID, datasetID string) *PiperPipelineConfiguration {
+	for i := range s.Pipelines {
+		if s.Pipelines[i].TenantID == tenantID && s.Pipelines[i].DatasetID == datasetID {
+			return &s.Pipelines[i]
+		}
+	}
+	return nil
+}
+
+// TakeSnapshot fetches the given accounts, or every account if none are
+// given, with all their tenants, datasets and cached pipeline configurations.
+// Datasets without a cached pipeline configuration are left out of Pipelines.
+func (c *Client) TakeSnapshot(ctx context.Context, accountIDs ...string) (*Snapshot, error) {
+	snap := &Snapshot{TakenAt: time.Now().UTC()}
+
+	if len(accountIDs) == 0 {
+		accounts, err := drain(c.IterateAccounts(ctx, PageOptions{Limit: listAllPageSize}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list accounts: %w", err)
+		}
+		snap.Accounts = accounts
+	} else {
+		for _, id := range accountIDs {
+			account, err := c.GetAccount(ctx, id)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get account %s: %w", id, err)
+			}
+			snap.Accounts = append(snap.Accounts, *account)
+		}
+	}
+
+	for _, account := range snap.Accounts {
+		tenants, err := drain(c.IterateTenants(ctx, account.ID, PageOptions{Limit: listAllPageSize}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tenants of account %s: %w", account.ID, err)
+		}
+		snap.Tenants = append(snap.Tenants, tenants...)
+	}
+
+	for _, tenant := range snap.Tenants {
+		datasets, err := drain(c.IterateDatasets(ctx, tenant.ID, PageOptions{Limit: listAllPageSize}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list datasets of tenant %s: %w", tenant.ID, err)
+		}
+		snap.Datasets = append(snap.Datasets, datasets...)
+	}
+
+	for _, dataset := range snap.Datasets {
+		pipeline, err := c.LookupCachedPipelineConfiguration(ctx, dataset.TenantID, dataset.ID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pipeline configuration of dataset %s: %w", dataset.ID, err)
+		}
+		snap.Pipelines = append(snap.Pipelines, *pipeline)
+	}
+
+	return snap, nil
+}
+
+// drain collects everything an iterator yields
+func drain[T any](it *Iterator[T]) ([]T, error) {
+	defer it.Close()
+
+	var items []T
+	for it.Next() {
+		items = append(items, it.Item())
+	}
+	return items, it.Err()
+}
+
+// RefreshSnapshot takes a snapshot and saves it to path. If the snapshot
+// can't be taken, it loads the one saved at path instead and returns it with
+// the error, so a service can start on its last known state. A non-nil
+// snapshot is usable even when the error is not nil.
+func (c *Client) RefreshSnapshot(ctx context.Context, path string, accountIDs ...string) (*Snapshot, error) {
+	snap, err := c.TakeSnapshot(ctx, accountIDs...)
+	if err != nil {
+		saved, loadErr := LoadSnapshot(path)
+		if loadErr != nil {
+			return nil, fmt.Errorf("%w (no saved snapshot to fall back on: %v)", err, loadErr)
+		}
+		return saved, err
+	}
+
+	if err := WriteSnapshot(path, snap); err != nil {
+		return snap, err
+	}
+	return snap, nil
+}
+
+// WriteSnapshot saves a snapshot to path. The file is replaced atomically, so
+// a crash mid-write leaves the previous snapshot in place. It is readable by
+// the owner only, as configuration may hold credentials.
+func WriteSnapshot(path string, snap *Snapshot) error {
+	body, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	data, err := json.Marshal(snapshotFile{
+		Version:  snapshotVersion,
+		Checksum: "sha256:" + hex.EncodeToString(sum[:]),
+		Snapshot: body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+
+	// Make the rename itself durable; not every platform supports this
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// LoadSnapshot reads a snapshot saved by WriteSnapshot, verifying its
+// checksum. Check its Age to decide whether it is too old to use.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var file snapshotFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshotInvalid, err)
+	}
+	if file.Version != snapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrSnapshotInvalid, file.Version)
+	}
+	sum := sha256.Sum256(file.Snapshot)
+	if file.Checksum != "sha256:"+hex.EncodeToString(sum[:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrSnapshotInvalid)
+	}
+
+	var snap Snapshot
+	if err := json.Unmarshal(file.Snapshot, &snap); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshotInvalid, err)
+	}
+	snap.FromFile = true
+
+	return &snap, nil
+}
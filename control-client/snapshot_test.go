@@ -0,0 +1,163 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSnapshot() *Snapshot {
+	return &Snapshot{
+		TakenAt:   time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+		Accounts:  []Account{{ID: "acc", Name: "Account"}},
+		Tenants:   []Tenant{{ID: "ten", AccountID: "acc", Name: "Tenant"}},
+		Datasets:  []Dataset{{ID: "ds1", TenantID: "ten"}, {ID: "ds2", TenantID: "ten"}},
+		Pipelines: []PiperPipelineConfiguration{{TenantID: "ten", DatasetID: "ds1", Configuration: map[string]interface{}{"filters": "none"}}},
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	want := testSnapshot()
+
+	if err := WriteSnapshot(path, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.FromFile {
+		t.Fatal("expected a loaded snapshot to be marked FromFile")
+	}
+	got.FromFile = false
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+
+	if p := got.Pipeline("ten", "ds1"); p == nil || p.Configuration["filters"] != "none" {
+		t.Fatalf("expected ds1's pipeline, got %+v", p)
+	}
+	if p := got.Pipeline("ten", "ds2"); p != nil {
+		t.Fatalf("expected no pipeline for ds2, got %+v", p)
+	}
+	if n := len(got.TenantDatasets("ten")); n != 2 {
+		t.Fatalf("expected 2 datasets for the tenant, got %d", n)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("expected the file to be readable by the owner only, got %v", perm)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Fatalf("expected no temporary files left behind, got %d entries", len(entries))
+	}
+}
+
+func TestSnapshotCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "snapshot.json")
+	if err := WriteSnapshot(path, testSnapshot()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string][]byte{
+		// Still valid JSON, but not what was summed
+		"checksum":  bytes.Replace(data, []byte(`"Account"`), []byte(`"Acc0unt"`), 1),
+		"truncated": data[:len(data)/2],
+		"version":   bytes.Replace(data, []byte(`"version":1`), []byte(`"version":2`), 1),
+	}
+	for name, corrupted := range cases {
+		if bytes.Equal(corrupted, data) {
+			t.Fatalf("%s: the test failed to corrupt the file", name)
+		}
+		p := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(p, corrupted, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadSnapshot(p); !errors.Is(err, ErrSnapshotInvalid) {
+			t.Fatalf("%s: expected ErrSnapshotInvalid, got %v", name, err)
+		}
+	}
+}
+
+func TestRefreshSnapshotFallsBackToFile(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	c := NewClient(Config{BaseURL: srv.URL, Retry: RetryPolicy{MaxAttempts: 1}})
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	// Without a saved snapshot there is nothing to fall back on
+	snap, err := c.RefreshSnapshot(context.Background(), path)
+	if snap != nil || err == nil {
+		t.Fatalf("expected no snapshot and an error, got %v and %v", snap, err)
+	}
+
+	saved := testSnapshot()
+	if err := WriteSnapshot(path, saved); err != nil {
+		t.Fatal(err)
+	}
+	snap, err = c.RefreshSnapshot(context.Background(), path)
+	if err == nil {
+		t.Fatal("expected the fetch error along with the saved snapshot")
+	}
+	if snap == nil || !snap.FromFile || !snap.TakenAt.Equal(saved.TakenAt) {
+		t.Fatalf("expected the saved snapshot, got %+v", snap)
+	}
+}
+
+func TestRefreshSnapshotSaves(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		switch {
+		case r.URL.Path == "/api/v1/accounts":
+			w.Write([]byte(`{"items": [{"id": "acc"}]}`))
+		case r.URL.Path == "/api/v1/accounts/acc/tenants":
+			w.Write([]byte(`{"items": [{"id": "ten", "account_id": "acc"}]}`))
+		case r.URL.Path == "/api/v1/tenants/ten/datasets":
+			w.Write([]byte(`{"items": [{"id": "ds1", "tenant_id": "ten"}, {"id": "ds2", "tenant_id": "ten"}]}`))
+		case r.URL.Path == "/api/v1/piper/cache/pipelines/ten/ds1":
+			w.Write([]byte(`{"tenant_id": "ten", "dataset_id": "ds1"}`))
+		case strings.HasPrefix(r.URL.Path, "/api/v1/piper/cache/pipelines/"):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := NewClient(Config{BaseURL: srv.URL})
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	snap, err := c.RefreshSnapshot(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.FromFile || len(snap.Accounts) != 1 || len(snap.Tenants) != 1 || len(snap.Datasets) != 2 || len(snap.Pipelines) != 1 {
+		t.Fatalf("expected 1 account, 1 tenant, 2 datasets and 1 pipeline, got %+v", snap)
+	}
+
+	saved, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Datasets) != 2 || saved.Pipeline("ten", "ds1") == nil {
+		t.Fatalf("expected the fetched snapshot to be saved, got %+v", saved)
+	}
+}
@@ -0,0 +1,188 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/bytedance/sonic"
+)
+
+// ErrResponseTooLarge is returned when a response body is larger than
+// Config.MaxResponseBytes
+var ErrResponseTooLarge = errors.New("response too large")
+
+// maxBytesReader fails with ErrResponseTooLarge once more than max bytes have
+// been read
+type maxBytesReader struct {
+	r         io.Reader
+	max       int64
+	remaining int64
+}
+
+func (c *Client) limitBody(r io.Reader) io.Reader {
+	return &maxBytesReader{r: r, max: c.maxResponseBytes, remaining: c.maxResponseBytes}
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.remaining < 0 {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, m.max)
+	}
+	// Read one byte past the limit to tell a body of exactly max bytes from
+	// a larger one
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+	n, err := m.r.Read(p)
+	if int64(n) > m.remaining {
+		n = int(m.remaining)
+		m.remaining = -1
+		return n, fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, m.max)
+	}
+	m.remaining -= int64(n)
+	return n, err
+}
+
+// StreamAllParquetFileMetadata walks all of a tenant/dataset's file metadata
+// like IterateAllParquetFileMetadata, but decodes the items as they arrive
+// instead of holding whole pages in memory, and calls fn for each. An error
+// from fn stops the walk and is returned.
+func (c *Client) StreamAllParquetFileMetadata(ctx context.Context, tenantID, datasetID string, opts PageOptions, fn func(*PackerParquetFileMetadata) error) error {
+	path := fmt.Sprintf("/api/v1/packer/metadata/files/%s/%s/all", tenantID, datasetID)
+	return streamAll(ctx, c, path, nil, opts, fn)
+}
+
+// StreamParquetFileMetadataByPartition is StreamAllParquetFileMetadata for
+// the files in one partition
+func (c *Client) StreamParquetFileMetadataByPartition(ctx context.Context, tenantID, datasetID, partitionPath string, opts PageOptions, fn func(*PackerParquetFileMetadata) error) error {
+	path := fmt.Sprintf("/api/v1/packer/metadata/files/%s/%s", tenantID, datasetID)
+	params := url.Values{}
+	if partitionPath != "" {
+		params.Set("partition_path", partitionPath)
+	}
+	return streamAll(ctx, c, path, params, opts, fn)
+}
+
+// streamAll streams every page of a list in turn
+func streamAll[T any](ctx context.Context, c *Client, path string, params url.Values, opts PageOptions, fn func(*T) error) error {
+	for {
+		// streamPage adds the page selection to the params it is given
+		pageParams := url.Values{}
+		for k, v := range params {
+			pageParams[k] = v
+		}
+
+		page, err := streamPage(ctx, c, path, pageParams, opts, fn)
+		if err != nil {
+			return err
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// streamPage fetches a page of a list like getPage, but decodes the items one
+// at a time and passes each to fn rather than collecting them. The returned
+// page has no Items.
+func streamPage[T any](ctx context.Context, c *Client, path string, params url.Values, opts PageOptions, fn func(*T) error) (*Page[T], error) {
+	opts.query(params)
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+
+	resp, err := c.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, c.parseResponse(resp, nil)
+	}
+	defer resp.Body.Close()
+
+	var page Page[T]
+	n, err := decodeItems(json.NewDecoder(c.limitBody(resp.Body)), &page, fn)
+	if err != nil {
+		return nil, err
+	}
+	page.fillNextCursor(opts, n)
+
+	return &page, nil
+}
+
+// decodeItems reads a list response object, passing each element of its
+// items array to fn and filling in the page's total and next cursor. It
+// returns the number of items.
+func decodeItems[T any](dec *json.Decoder, page *Page[T], fn func(*T) error) (int, error) {
+	if err := expectDelim(dec, '{'); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return n, fmt.Errorf("failed to decode response: %w", err)
+		}
+		key, _ := tok.(string)
+
+		switch key {
+		case "items":
+			if err := expectDelim(dec, '['); err != nil {
+				return n, err
+			}
+			for dec.More() {
+				// Only one item is held at a time. It is decoded with sonic,
+				// as everywhere else, once the streaming decoder has cut it out.
+				var raw json.RawMessage
+				if err := dec.Decode(&raw); err != nil {
+					return n, fmt.Errorf("failed to decode response: %w", err)
+				}
+				var item T
+				if err := sonic.Unmarshal(raw, &item); err != nil {
+					return n, fmt.Errorf("failed to decode item %d: %w", n, err)
+				}
+				n++
+				if err := fn(&item); err != nil {
+					return n, err
+				}
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return n, err
+			}
+		case "total":
+			if err := dec.Decode(&page.Total); err != nil {
+				return n, fmt.Errorf("failed to decode response: %w", err)
+			}
+		case "next_cursor":
+			if err := dec.Decode(&page.NextCursor); err != nil {
+				return n, fmt.Errorf("failed to decode response: %w", err)
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return n, fmt.Errorf("failed to decode response: %w", err)
+			}
+		}
+	}
+
+	return n, expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("failed to decode response: expected %q, got %v", want, tok)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+type streamItem struct {
+	ID int `json:"id"`
+}
+
+func TestDecodeItems(t *testing.T) {
+	// Fields may come in any order, and unknown ones are skipped
+	input := `{"total": 3, "items": [{"id": 1}, {"id": 2}, {"id": 3}], "extra": {"nested": [1, 2]}, "next_cursor": "abc"}`
+
+	var page Page[streamItem]
+	var ids []int
+	n, err := decodeItems(json.NewDecoder(strings.NewReader(input)), &page, func(item *streamItem) error {
+		ids = append(ids, item.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || fmt.Sprint(ids) != "[1 2 3]" {
+		t.Fatalf("expected items 1, 2 and 3, got %d items %v", n, ids)
+	}
+	if page.Total != 3 || page.NextCursor != "abc" {
+		t.Fatalf("expected total 3 and cursor abc, got %d and %q", page.Total, page.NextCursor)
+	}
+	if len(page.Items) != 0 {
+		t.Fatalf("expected items not to be collected, got %d", len(page.Items))
+	}
+}
+
+func TestDecodeItemsOneByteAtATime(t *testing.T) {
+	input := `{"items": [{"id": 1}, {"id": 2}]}`
+
+	var page Page[streamItem]
+	n, err := decodeItems(json.NewDecoder(iotest.OneByteReader(strings.NewReader(input))), &page, func(item *streamItem) error {
+		return nil
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 items, got %d and %v", n, err)
+	}
+}
+
+func TestDecodeItemsCallbackError(t *testing.T) {
+	input := `{"items": [{"id": 1}, {"id": 2}, {"id": 3}]}`
+	stop := errors.New("stop")
+
+	var page Page[streamItem]
+	n, err := decodeItems(json.NewDecoder(strings.NewReader(input)), &page, func(item *streamItem) error {
+		if item.ID == 2 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("expected the callback's error, got %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected to stop at the second item, got %d", n)
+	}
+}
+
+func TestDecodeItemsMalformed(t *testing.T) {
+	for _, input := range []string{
+		`[{"id": 1}]`,
+		`{"items": {"id": 1}}`,
+		`{"items": [{"id": "one"}]}`,
+		`{"items": [{"id": 1}`,
+	} {
+		var page Page[streamItem]
+		_, err := decodeItems(json.NewDecoder(strings.NewReader(input)), &page, func(item *streamItem) error {
+			return nil
+		})
+		if err == nil {
+			t.Fatalf("expected an error decoding %s", input)
+		}
+	}
+}
+
+func TestMaxBytesReader(t *testing.T) {
+	c := &Client{maxResponseBytes: 10}
+
+	for _, r := range []func(string) io.Reader{
+		func(s string) io.Reader { return strings.NewReader(s) },
+		func(s string) io.Reader { return iotest.OneByteReader(strings.NewReader(s)) },
+	} {
+		// Exactly at the limit
+		data, err := io.ReadAll(c.limitBody(r("0123456789")))
+		if err != nil {
+			t.Fatalf("expected a body of exactly the limit to be read, got %v", err)
+		}
+		if string(data) != "0123456789" {
+			t.Fatalf("expected the whole body, got %q", data)
+		}
+
+		// One byte over
+		data, err = io.ReadAll(c.limitBody(r("0123456789A")))
+		if !errors.Is(err, ErrResponseTooLarge) {
+			t.Fatalf("expected ErrResponseTooLarge, got %v", err)
+		}
+		if len(data) > 10 {
+			t.Fatalf("expected at most 10 bytes before the error, got %d", len(data))
+		}
+	}
+}
+
+func TestStreamAllParquetFileMetadata(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			w.Write([]byte(`{"items": [{"id": 1}, {"id": 2}], "next_cursor": "page2"}`))
+		case "page2":
+			w.Write([]byte(`{"items": [{"id": 3}]}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	c := NewClient(Config{BaseURL: srv.URL})
+
+	var ids []int64
+	err := c.StreamAllParquetFileMetadata(context.Background(), "t", "d", PageOptions{}, func(m *PackerParquetFileMetadata) error {
+		ids = append(ids, m.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids) != "[1 2 3]" {
+		t.Fatalf("expected items 1, 2 and 3 across both pages, got %v", ids)
+	}
+	if srv.Calls() != 2 {
+		t.Fatalf("expected 2 requests, got %d", srv.Calls())
+	}
+}
+
+func TestStreamResponseTooLarge(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.Write([]byte(`{"items": [{"id": 1}, {"id": 2}, {"id": 3}]}`))
+	})
+	c := NewClient(Config{BaseURL: srv.URL, MaxResponseBytes: 20})
+
+	err := c.StreamAllParquetFileMetadata(context.Background(), "t", "d", PageOptions{}, func(m *PackerParquetFileMetadata) error {
+		return nil
+	})
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("expected ErrResponseTooLarge, got %v", err)
+	}
+}
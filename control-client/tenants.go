@@ -42,25 +42,19 @@ func (c *Client) GetTenant(ctx context.Context, accountID, tenantID string) (*Te
 
 // ListTenants retrieves all tenants for an account
 func (c *Client) ListTenants(ctx context.Context, accountID string, limit int) ([]Tenant, error) {
-	path := fmt.Sprintf("/api/v1/accounts/%s/tenants", accountID)
-	if limit > 0 {
-		path = fmt.Sprintf("%s?limit=%d", path, limit)
-	}
-
-	resp, err := c.doRequest(ctx, "GET", path, nil)
-	if err != nil {
-		return nil, err
-	}
+	return collectPage(c.ListTenantsPage(ctx, accountID, PageOptions{Limit: limit}))
+}
 
-	var result struct {
-		Items []Tenant `json:"items"`
-		Total int      `json:"total"`
-	}
-	if err := c.parseResponse(resp, &result); err != nil {
-		return nil, err
-	}
+// ListTenantsPage retrieves one page of an account's tenants
+func (c *Client) ListTenantsPage(ctx context.Context, accountID string, opts PageOptions) (*Page[Tenant], error) {
+	return getPage[Tenant](ctx, c, fmt.Sprintf("/api/v1/accounts/%s/tenants", accountID), nil, opts)
+}
 
-	return result.Items, nil
+// IterateTenants walks all of an account's tenants, opts.Limit at a time
+func (c *Client) IterateTenants(ctx context.Context, accountID string, opts PageOptions) *Iterator[Tenant] {
+	return newIterator(ctx, opts, func(ctx context.Context, opts PageOptions) (*Page[Tenant], error) {
+		return c.ListTenantsPage(ctx, accountID, opts)
+	})
 }
 
 // UpdateTenant updates an existing tenant
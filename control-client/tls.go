@@ -0,0 +1,250 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfig configures TLS, and optionally mutual TLS, to the Control Service.
+// Certificates and keys can be given as file paths or PEM bytes. Files are
+// re-read when they change on disk, so rotated certificates are picked up
+// without recreating the Client.
+type TLSConfig struct {
+	// CAFile or CAPEM is the CA bundle used to verify the server. If neither
+	// is set, the system roots are used.
+	CAFile string
+	CAPEM  []byte
+	// CertFile and KeyFile, or CertPEM and KeyPEM, are the client certificate
+	// and key presented for mutual TLS
+	CertFile string
+	KeyFile  string
+	CertPEM  []byte
+	KeyPEM   []byte
+	// ServerName overrides the name the server certificate is verified
+	// against, for when BaseURL uses an IP or internal alias
+	ServerName string
+	// MinVersion is the minimum TLS version, e.g. tls.VersionTLS13
+	// (default tls.VersionTLS12)
+	MinVersion uint16
+	// ReloadInterval is how often certificate files are checked for changes
+	// (default 30s)
+	ReloadInterval time.Duration
+}
+
+// NewTLSConfig builds the crypto/tls configuration the client uses for config.
+// NewClient calls it itself; call it directly to check the configuration at
+// startup, or to reuse it with a custom transport.
+func NewTLSConfig(config TLSConfig) (*tls.Config, error) {
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if config.ReloadInterval <= 0 {
+		config.ReloadInterval = 30 * time.Second
+	}
+
+	r := &tlsReloader{config: config}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: config.MinVersion,
+		ServerName: config.ServerName,
+	}
+
+	if r.hasClientCert() {
+		tlsConfig.GetClientCertificate = r.clientCertificate
+	}
+
+	switch {
+	case config.CAFile != "":
+		// The CA bundle can change under us, which a static RootCAs can't
+		// follow, so verify the chain ourselves against the current bundle.
+		// Standard verification is only skipped in favour of this one.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = r.verifyConnection
+	case len(config.CAPEM) > 0:
+		tlsConfig.RootCAs = r.pool
+	}
+
+	return tlsConfig, nil
+}
+
+// applyTLS sets up the client's transport for config. A bad TLS setup fails
+// every request rather than NewClient, which can't return an error; use
+// NewTLSConfig to check it up front.
+func (c *Client) applyTLS(config TLSConfig) {
+	tlsConfig, err := NewTLSConfig(config)
+	if err != nil {
+		c.configErr = fmt.Errorf("invalid TLS configuration: %w", err)
+		return
+	}
+
+	var transport *http.Transport
+	switch rt := c.httpClient.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		// Cloned, so a transport shared with other clients is left alone
+		transport = rt.Clone()
+	default:
+		c.configErr = fmt.Errorf("invalid TLS configuration: can't apply it to a %T transport; "+
+			"set TLSClientConfig from NewTLSConfig on the transport instead", rt)
+		return
+	}
+	transport.TLSClientConfig = tlsConfig
+	c.httpClient.Transport = transport
+}
+
+// tlsReloader holds the current certificates and reloads them from disk when
+// their files change
+type tlsReloader struct {
+	config TLSConfig
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	lastCheck time.Time
+	modTimes  map[string]time.Time
+}
+
+func (r *tlsReloader) hasClientCert() bool {
+	return r.config.CertFile != "" || len(r.config.CertPEM) > 0
+}
+
+// load reads all certificates, failing if any can't be used
+func (r *tlsReloader) load() error {
+	if (r.config.CertFile == "") != (r.config.KeyFile == "") {
+		return errors.New("TLS client certificate and key files must be set together")
+	}
+	if (len(r.config.CertPEM) == 0) != (len(r.config.KeyPEM) == 0) {
+		return errors.New("TLS client certificate and key PEM must be set together")
+	}
+
+	r.modTimes = make(map[string]time.Time)
+	for _, name := range []string{r.config.CAFile, r.config.CertFile, r.config.KeyFile} {
+		if name == "" {
+			continue
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			return fmt.Errorf("failed to read TLS file: %w", err)
+		}
+		r.modTimes[name] = fi.ModTime()
+	}
+
+	cert, pool, err := r.read()
+	if err != nil {
+		return err
+	}
+	r.cert, r.pool = cert, pool
+	r.lastCheck = time.Now()
+	return nil
+}
+
+// read loads the certificate and CA pool from the configured files or PEM
+func (r *tlsReloader) read() (*tls.Certificate, *x509.CertPool, error) {
+	var cert *tls.Certificate
+	switch {
+	case r.config.CertFile != "":
+		c, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load TLS client certificate: %w", err)
+		}
+		cert = &c
+	case len(r.config.CertPEM) > 0:
+		c, err := tls.X509KeyPair(r.config.CertPEM, r.config.KeyPEM)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse TLS client certificate: %w", err)
+		}
+		cert = &c
+	}
+
+	caPEM := r.config.CAPEM
+	if r.config.CAFile != "" {
+		var err error
+		caPEM, err = os.ReadFile(r.config.CAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read TLS CA file: %w", err)
+		}
+	}
+	var pool *x509.CertPool
+	if len(caPEM) > 0 {
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, nil, errors.New("TLS CA bundle contains no certificates")
+		}
+	}
+
+	return cert, pool, nil
+}
+
+// current returns the certificate and pool, reloading them first if the
+// files changed since the last check. A failed reload keeps the previous
+// certificates, as a rotation may be caught halfway through.
+func (r *tlsReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.modTimes) == 0 || time.Since(r.lastCheck) < r.config.ReloadInterval {
+		return r.cert, r.pool
+	}
+	r.lastCheck = time.Now()
+
+	changed := false
+	modTimes := make(map[string]time.Time, len(r.modTimes))
+	for name, old := range r.modTimes {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return r.cert, r.pool
+		}
+		modTimes[name] = fi.ModTime()
+		changed = changed || !fi.ModTime().Equal(old)
+	}
+	if !changed {
+		return r.cert, r.pool
+	}
+
+	cert, pool, err := r.read()
+	if err != nil {
+		return r.cert, r.pool
+	}
+	r.cert, r.pool, r.modTimes = cert, pool, modTimes
+	return r.cert, r.pool
+}
+
+func (r *tlsReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert, _ := r.current()
+	if cert == nil {
+		return &tls.Certificate{}, nil
+	}
+	return cert, nil
+}
+
+// verifyConnection verifies the server's chain against the current CA bundle
+func (r *tlsReloader) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	_, pool := r.current()
+
+	intermediates := x509.NewCertPool()
+	for _, c := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       cs.ServerName,
+		Intermediates: intermediates,
+	})
+	return err
+}
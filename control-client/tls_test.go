@@ -0,0 +1,155 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// otherCAPEM returns a self-signed CA certificate that didn't sign the test
+// server's certificate
+func otherCAPEM(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTLSCAFileVerification(t *testing.T) {
+	srv, trusted := newTLSTestServer(t)
+
+	cases := map[string]struct {
+		ca      []byte
+		wantErr bool
+	}{
+		"matching CA": {trusted.CAPEM, false},
+		"other CA":    {otherCAPEM(t), true},
+	}
+	for name, tc := range cases {
+		c := NewClient(Config{
+			BaseURL: srv.URL,
+			Retry:   RetryPolicy{MaxAttempts: 1},
+			TLS:     &TLSConfig{CAFile: writeFile(t, "ca.pem", tc.ca)},
+		})
+		err := c.HealthCheck(context.Background())
+		if tc.wantErr && (err == nil || !strings.Contains(err.Error(), "certificate")) {
+			t.Fatalf("%s: expected a certificate verification error, got %v", name, err)
+		}
+		if !tc.wantErr && err != nil {
+			t.Fatalf("%s: expected the server to be trusted, got %v", name, err)
+		}
+	}
+}
+
+func TestTLSCAFileReload(t *testing.T) {
+	srv, trusted := newTLSTestServer(t)
+	path := writeFile(t, "ca.pem", otherCAPEM(t))
+	c := NewClient(Config{
+		BaseURL: srv.URL,
+		Retry:   RetryPolicy{MaxAttempts: 1},
+		TLS:     &TLSConfig{CAFile: path, ReloadInterval: time.Millisecond},
+	})
+
+	if err := c.HealthCheck(context.Background()); err == nil {
+		t.Fatal("expected the server to be rejected with the other CA")
+	}
+
+	// Rotate the bundle; the modification time is moved on in case the
+	// filesystem's resolution hides the change
+	if err := os.WriteFile(path, trusted.CAPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	if err := c.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("expected the rotated CA bundle to be used, got %v", err)
+	}
+}
+
+func TestTLSCAPEM(t *testing.T) {
+	srv, trusted := newTLSTestServer(t)
+	config, err := NewTLSConfig(trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.RootCAs == nil || config.InsecureSkipVerify || config.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("expected standard verification against the bundle with TLS 1.2 or later, got %+v", config)
+	}
+
+	c := NewClient(Config{BaseURL: srv.URL, Retry: RetryPolicy{MaxAttempts: 1}, TLS: &TLSConfig{CAPEM: otherCAPEM(t)}})
+	if err := c.HealthCheck(context.Background()); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("expected a certificate verification error, got %v", err)
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+	garbage := []byte("-----BEGIN CERTIFICATE-----\nbm90IGEgY2VydA==\n-----END CERTIFICATE-----\n")
+
+	cases := map[string]struct {
+		config TLSConfig
+		want   string
+	}{
+		"bad CA PEM":        {TLSConfig{CAPEM: garbage}, "contains no certificates"},
+		"bad CA file":       {TLSConfig{CAFile: writeFile(t, "ca.pem", []byte("not PEM"))}, "contains no certificates"},
+		"missing CA file":   {TLSConfig{CAFile: missing}, "failed to read TLS file"},
+		"missing cert file": {TLSConfig{CertFile: missing, KeyFile: missing}, "failed to read TLS file"},
+		"bad cert PEM":      {TLSConfig{CertPEM: garbage, KeyPEM: garbage}, "failed to parse TLS client certificate"},
+		"cert without key":  {TLSConfig{CertFile: missing}, "must be set together"},
+	}
+	for name, tc := range cases {
+		if _, err := NewTLSConfig(tc.config); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected an error with %q, got %v", name, tc.want, err)
+		}
+
+		// The client reports it on every request instead
+		config := tc.config
+		c := NewClient(Config{BaseURL: "https://127.0.0.1:1", TLS: &config})
+		err := c.HealthCheck(context.Background())
+		if err == nil || !strings.Contains(err.Error(), "invalid TLS configuration") {
+			t.Fatalf("%s: expected an invalid TLS configuration error, got %v", name, err)
+		}
+	}
+}
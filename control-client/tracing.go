@@ -0,0 +1,127 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Every call sends an X-Request-ID and a W3C traceparent header so it can be
+// found in the Control Service's logs. Both are taken from the call's context
+// when set there, and generated otherwise.
+
+type requestIDKey struct{}
+
+type traceParentKey struct{}
+
+// WithRequestID returns a context whose calls send id as their X-Request-ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID set with WithRequestID, or ""
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithTraceParent returns a context whose calls send traceParent, a W3C
+// traceparent header value such as the one on an incoming request, so they
+// join the caller's trace. Invalid values are ignored.
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if _, ok := parseTraceParent(traceParent); !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, traceParentKey{}, traceParent)
+}
+
+// TraceParentFromContext returns the traceparent set with WithTraceParent, or ""
+func TraceParentFromContext(ctx context.Context) string {
+	tp, _ := ctx.Value(traceParentKey{}).(string)
+	return tp
+}
+
+// requestTrace identifies one call, across all its attempts
+type requestTrace struct {
+	requestID   string
+	traceParent string
+	traceID     string
+}
+
+type requestTraceKey struct{}
+
+// withRequestTrace fills in the call's request ID and traceparent from ctx,
+// generating whichever is missing, and returns a context carrying them for send
+func withRequestTrace(ctx context.Context) (context.Context, requestTrace) {
+	t := requestTrace{
+		requestID:   RequestIDFromContext(ctx),
+		traceParent: TraceParentFromContext(ctx),
+	}
+	if t.requestID == "" {
+		t.requestID = randomHex(16)
+	}
+	if t.traceParent == "" {
+		t.traceParent = "00-" + randomHex(16) + "-" + randomHex(8) + "-01"
+	}
+	t.traceID, _ = parseTraceParent(t.traceParent)
+	return context.WithValue(ctx, requestTraceKey{}, t), t
+}
+
+// setTraceHeaders sets the headers for the call ctx belongs to
+func setTraceHeaders(ctx context.Context, req *http.Request) {
+	if t, ok := ctx.Value(requestTraceKey{}).(requestTrace); ok {
+		req.Header.Set("X-Request-ID", t.requestID)
+		req.Header.Set("traceparent", t.traceParent)
+	}
+}
+
+// parseTraceParent returns the trace ID from a version 00 traceparent value
+func parseTraceParent(tp string) (string, bool) {
+	parts := strings.Split(tp, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", false
+	}
+	for _, p := range parts[1:] {
+		if _, err := hex.DecodeString(p); err != nil {
+			return "", false
+		}
+	}
+	if parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logRequest logs a finished call at DEBUG, if the client has a logger
+func (c *Client) logRequest(ctx context.Context, t requestTrace, method, path string, resp *http.Response, err error, elapsed time.Duration) {
+	if c.logger == nil || !c.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("path", path),
+		slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+		slog.String("request_id", t.requestID),
+		slog.String("trace_id", t.traceID),
+	}
+	if resp != nil {
+		attrs = append(attrs, slog.Int("status", resp.StatusCode))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	c.logger.LogAttrs(ctx, slog.LevelDebug, "control request", attrs...)
+}
@@ -0,0 +1,71 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package controlclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"testing"
+)
+
+func TestRequestLogging(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	var gotTraceParent, gotRequestID string
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		gotTraceParent = r.Header.Get("traceparent")
+		gotRequestID = r.Header.Get("X-Request-ID")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	c := NewClient(Config{BaseURL: srv.URL, Logger: logger})
+
+	ctx := WithTraceParent(context.Background(), "00-"+traceID+"-00f067aa0ba902b7-01")
+	ctx = WithRequestID(ctx, "req-1")
+	if err := c.HealthCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if gotRequestID != "req-1" || len(gotTraceParent) != 55 || gotTraceParent[3:35] != traceID {
+		t.Fatalf("expected the request ID and trace to be sent, got %q and %q", gotRequestID, gotTraceParent)
+	}
+
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("expected one JSON record, got %q: %v", buf.String(), err)
+	}
+	if rec["level"] != "DEBUG" || rec["msg"] != "control request" {
+		t.Fatalf("expected a DEBUG control request record, got %v", rec)
+	}
+	// The IDs are attributes rather than message text, so they can be searched
+	if rec["trace_id"] != traceID || rec["request_id"] != "req-1" {
+		t.Fatalf("expected trace_id and request_id attributes, got %v", rec)
+	}
+	if rec["method"] != "GET" || rec["path"] != "/api/v1/health" || rec["status"] != float64(200) {
+		t.Fatalf("expected the method, path and status, got %v", rec)
+	}
+	if _, ok := rec["duration_ms"].(float64); !ok {
+		t.Fatalf("expected a numeric duration_ms, got %v", rec["duration_ms"])
+	}
+}
+
+func TestRequestLoggingBelowDebug(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	c := NewClient(Config{BaseURL: srv.URL, Logger: logger})
+
+	if err := c.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing logged above DEBUG, got %q", buf.String())
+	}
+}
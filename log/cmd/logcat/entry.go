@@ -0,0 +1,118 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bytefreezer/goodies/log"
+)
+
+// entry is a single input line, decoded when it holds a JSON log record
+type entry struct {
+	raw    string
+	isJSON bool
+	fields map[string]any
+
+	time       time.Time
+	level      slog.Level
+	levelName  string
+	msg        string
+	traceID    string
+	customerID string
+}
+
+// parseEntry decodes line as a log record. Lines that are not JSON objects,
+// such as output from Print/Printf, are kept as plain text entries.
+func parseEntry(line string) *entry {
+	e := &entry{raw: line}
+
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") {
+		return e
+	}
+
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	dec.UseNumber()
+	if err := dec.Decode(&e.fields); err != nil {
+		return e
+	}
+	e.isJSON = true
+
+	if s, ok := e.fields[slog.TimeKey].(string); ok {
+		e.time, _ = time.Parse(time.RFC3339Nano, s)
+	}
+	if s, ok := e.fields[slog.LevelKey].(string); ok {
+		e.levelName = s
+		e.level, _ = parseLevel(s)
+	}
+	e.msg, _ = e.fields[slog.MessageKey].(string)
+	e.traceID = stringValue(e.fields[log.TraceKey])
+	e.customerID = stringValue(e.fields[log.CustomerKey])
+
+	return e
+}
+
+// parseLevel understands the level names written by the log package,
+// including FATAL and slog offsets such as "INFO+2"
+func parseLevel(s string) (slog.Level, error) {
+	if strings.EqualFold(s, "FATAL") {
+		return log.LevelFatal, nil
+	}
+	var l slog.Level
+	err := l.UnmarshalText([]byte(s))
+	return l, err
+}
+
+// lookup resolves a dotted path such as "source.file" against the record
+func (e *entry) lookup(path string) (any, bool) {
+	if !e.isJSON {
+		return nil, false
+	}
+	if v, ok := e.fields[path]; ok {
+		return v, true
+	}
+
+	var cur any = e.fields
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// stringValue renders a decoded JSON value the way it would be typed on the
+// command line
+func stringValue(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case bool:
+		if t {
+			return "true"
+		}
+		return "false"
+	default:
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(t); err != nil {
+			return ""
+		}
+		return strings.TrimSpace(b.String())
+	}
+}
@@ -0,0 +1,199 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// filter decides which entries are printed. The zero value matches everything.
+type filter struct {
+	minLevel   *slog.Level
+	since      time.Time
+	until      time.Time
+	traceID    string
+	customerID string
+	exprs      []expr
+}
+
+func newFilter(level, since, until, traceID, customerID string, where []string) (*filter, error) {
+	f := &filter{traceID: traceID, customerID: customerID}
+
+	if level != "" {
+		l, err := parseLevel(level)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -level %q: %w", level, err)
+		}
+		f.minLevel = &l
+	}
+
+	now := time.Now()
+	var err error
+	if since != "" {
+		if f.since, err = parseTimeBound(since, now); err != nil {
+			return nil, fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+	if until != "" {
+		if f.until, err = parseTimeBound(until, now); err != nil {
+			return nil, fmt.Errorf("invalid -until: %w", err)
+		}
+	}
+
+	for _, w := range where {
+		x, err := parseExpr(w)
+		if err != nil {
+			return nil, err
+		}
+		f.exprs = append(f.exprs, x)
+	}
+
+	return f, nil
+}
+
+// active reports whether any criteria were given
+func (f *filter) active() bool {
+	return f.minLevel != nil || !f.since.IsZero() || !f.until.IsZero() ||
+		f.traceID != "" || f.customerID != "" || len(f.exprs) > 0
+}
+
+func (f *filter) match(e *entry) bool {
+	if !e.isJSON {
+		// Plain text lines carry nothing to filter on, so only show them
+		// when no filtering was asked for
+		return !f.active()
+	}
+	if f.minLevel != nil && e.level < *f.minLevel {
+		return false
+	}
+	if !f.since.IsZero() && e.time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && !e.time.Before(f.until) {
+		return false
+	}
+	if f.traceID != "" && e.traceID != f.traceID {
+		return false
+	}
+	if f.customerID != "" && e.customerID != f.customerID {
+		return false
+	}
+	for _, x := range f.exprs {
+		if !x.match(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTimeBound accepts an absolute time or a duration counted back from now
+func parseTimeBound(s string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a time nor a duration", s)
+}
+
+// operators, longest first so that ">=" wins over ">"
+var operators = []string{"!=", "!~", ">=", "<=", "=", "~", ">", "<"}
+
+// expr is a single -where comparison such as status>=500
+type expr struct {
+	key   string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+func parseExpr(s string) (expr, error) {
+	for i := 0; i < len(s); i++ {
+		for _, op := range operators {
+			if !strings.HasPrefix(s[i:], op) {
+				continue
+			}
+			x := expr{
+				key:   strings.TrimSpace(s[:i]),
+				op:    op,
+				value: strings.TrimSpace(s[i+len(op):]),
+			}
+			if x.key == "" {
+				return expr{}, fmt.Errorf("invalid -where %q: missing attribute name", s)
+			}
+			if op == "~" || op == "!~" {
+				re, err := regexp.Compile(x.value)
+				if err != nil {
+					return expr{}, fmt.Errorf("invalid -where %q: %w", s, err)
+				}
+				x.re = re
+			}
+			return x, nil
+		}
+	}
+	return expr{}, fmt.Errorf("invalid -where %q: expected key=value, key!=value, key~regex, key!~regex or a numeric comparison", s)
+}
+
+func (x expr) match(e *entry) bool {
+	v, ok := e.lookup(x.key)
+	if !ok {
+		// A missing attribute satisfies only the negated operators
+		return x.op == "!=" || x.op == "!~"
+	}
+	s := stringValue(v)
+
+	switch x.op {
+	case "=":
+		return s == x.value
+	case "!=":
+		return s != x.value
+	case "~":
+		return x.re.MatchString(s)
+	case "!~":
+		return !x.re.MatchString(s)
+	}
+
+	cmp := compareValues(s, x.value)
+	switch x.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// compareValues compares numerically when both sides are numbers, as times
+// when both are RFC3339 timestamps, and lexically otherwise
+func compareValues(a, b string) int {
+	if fa, err := strconv.ParseFloat(a, 64); err == nil {
+		if fb, err := strconv.ParseFloat(b, 64); err == nil {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	if ta, err := time.Parse(time.RFC3339Nano, a); err == nil {
+		if tb, err := time.Parse(time.RFC3339Nano, b); err == nil {
+			return ta.Compare(tb)
+		}
+	}
+	return strings.Compare(a, b)
+}
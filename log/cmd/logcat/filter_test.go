@@ -0,0 +1,108 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bytefreezer/goodies/log"
+)
+
+const (
+	infoLine  = `{"time":"2026-01-02T10:00:00Z","level":"INFO","source":{"file":"/ci/build/svc/main.go","line":12},"msg":"started","traceId":"t1","status":200}`
+	errorLine = `{"time":"2026-01-02T10:05:00Z","level":"ERROR","msg":"request failed","traceId":"t2","customerId":"acme","status":503,"path":"/api/v1/tenants"}`
+	fatalLine = `{"time":"2026-01-02T10:06:00Z","level":"FATAL","msg":"giving up","traceId":"t1"}`
+)
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		level string
+		since string
+		trace string
+		where []string
+		want  []string
+	}{
+		{name: "no filter", want: []string{infoLine, errorLine, fatalLine, "plain text"}},
+		{name: "level", level: "error", want: []string{errorLine, fatalLine}},
+		{name: "since", since: "2026-01-02T10:01:00Z", want: []string{errorLine, fatalLine}},
+		{name: "trace", trace: "t1", want: []string{infoLine, fatalLine}},
+		{name: "numeric", where: []string{"status>=500"}, want: []string{errorLine}},
+		{name: "regex", where: []string{"path~^/api/"}, want: []string{errorLine}},
+		{name: "nested", where: []string{"source.file~main.go$"}, want: []string{infoLine}},
+		{name: "not equal", where: []string{"customerId!=acme"}, want: []string{infoLine, fatalLine}},
+	}
+
+	for _, tt := range tests {
+		f, err := newFilter(tt.level, tt.since, "", tt.trace, "", tt.where)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got []string
+		for _, line := range []string{infoLine, errorLine, fatalLine, "plain text"} {
+			if f.match(parseEntry(line)) {
+				got = append(got, line)
+			}
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestParseExprInvalid(t *testing.T) {
+	for _, s := range []string{"status", "=500", "path~["} {
+		if _, err := parseExpr(s); err == nil {
+			t.Fatalf("expected error for %q", s)
+		}
+	}
+}
+
+func TestFatalLevel(t *testing.T) {
+	e := parseEntry(fatalLine)
+	if e.level != log.LevelFatal {
+		t.Fatalf("expected fatal level, got %v", e.level)
+	}
+}
+
+func TestReadGzip(t *testing.T) {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(infoLine + "\n" + errorLine + "\n"))
+	zw.Close()
+
+	name := filepath.Join(t.TempDir(), "app.log.gz")
+	if err := os.WriteFile(name, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	err := readAll(context.Background(), []string{name}, false, func(line string) {
+		lines = append(lines, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[1] != errorLine {
+		t.Fatalf("expected 2 decompressed lines, got %v", lines)
+	}
+}
+
+func TestPrettyPrint(t *testing.T) {
+	var b bytes.Buffer
+	p := newPrinter(&b, false, false)
+	p.print(parseEntry(errorLine))
+	got := b.String()
+	for _, want := range []string{"ERROR", "request failed", "status=503", "traceId=t2", "customerId=acme"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in %q", want, got)
+		}
+	}
+}
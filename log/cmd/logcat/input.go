@@ -0,0 +1,152 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// followInterval is how often a followed file is polled for new data
+const followInterval = 250 * time.Millisecond
+
+// readAll reads every input in order and hands each line to emit. In follow
+// mode the files are read concurrently and kept open until ctx is cancelled.
+func readAll(ctx context.Context, files []string, follow bool, emit func(string)) error {
+	if !follow {
+		for _, name := range files {
+			if err := readInput(ctx, name, emit); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(files))
+	for i, name := range files {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			if name == "-" {
+				errs[i] = readInput(ctx, name, emit)
+				return
+			}
+			errs[i] = followFile(ctx, name, emit)
+		}(i, name)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// readInput reads a single file (or stdin for "-") to the end
+func readInput(ctx context.Context, name string, emit func(string)) error {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	br, err := decompress(bufio.NewReader(r))
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		line, err := br.ReadString('\n')
+		if line != "" {
+			emit(strings.TrimRight(line, "\r\n"))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+}
+
+// decompress transparently unwraps gzip input, detected by its magic bytes
+func decompress(br *bufio.Reader) (*bufio.Reader, error) {
+	magic, err := br.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		// Short or empty input is not gzip; let the caller see it as-is
+		return br, nil
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gzip stream: %w", err)
+	}
+	return bufio.NewReader(zr), nil
+}
+
+// followFile reads name to the end and then keeps polling for appended lines,
+// starting over from the beginning if the file is truncated
+func followFile(ctx context.Context, name string, emit func(string)) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return fmt.Errorf("%s: cannot follow gzip compressed input", name)
+	}
+
+	var offset int64
+	var partial string
+	for {
+		chunk, err := br.ReadString('\n')
+		offset += int64(len(chunk))
+		partial += chunk
+		if strings.HasSuffix(partial, "\n") {
+			emit(strings.TrimRight(partial, "\r\n"))
+			partial = ""
+		}
+		if err == nil {
+			continue
+		}
+		if err != io.EOF {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(followInterval):
+		}
+
+		fi, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if fi.Size() < offset {
+			// File was truncated (e.g. copytruncate rotation), start over
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			offset = 0
+			partial = ""
+		}
+		br.Reset(f)
+	}
+}
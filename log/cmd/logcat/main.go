@@ -0,0 +1,133 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+// Command logcat filters and pretty-prints the JSON logs written by the
+// goodies log package.
+//
+// Usage:
+//
+//	logcat [flags] [file ...]
+//
+// With no files, or when a file is "-", logcat reads standard input. Gzip
+// compressed input is detected automatically.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// multiFlag collects every occurrence of a repeatable flag
+type multiFlag []string
+
+func (m *multiFlag) String() string {
+	return strings.Join(*m, ", ")
+}
+
+func (m *multiFlag) Set(v string) error {
+	*m = append(*m, v)
+	return nil
+}
+
+func main() {
+	var (
+		level    = flag.String("level", "", "minimum level to show (debug, info, warn, error, fatal)")
+		since    = flag.String("since", "", "only show records at or after this time (RFC3339, date, or duration such as 15m)")
+		until    = flag.String("until", "", "only show records before this time (RFC3339, date, or duration such as 15m)")
+		trace    = flag.String("trace", "", "only show records with this traceId")
+		customer = flag.String("customer", "", "only show records with this customerId")
+		follow   = flag.Bool("f", false, "follow files as they grow")
+		group    = flag.Bool("group", false, "group records by traceId (reads all input before printing)")
+		color    = flag.String("color", "auto", "colorize output: auto, always or never")
+		raw      = flag.Bool("json", false, "print matching records as raw JSON instead of pretty-printing")
+		where    multiFlag
+	)
+	flag.Var(&where, "where", "attribute expression such as 'status>=500', 'path~^/api' or 'source.file!=db.go' (repeatable)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: logcat [flags] [file ...]\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	f, err := newFilter(*level, *since, *until, *trace, *customer, where)
+	if err != nil {
+		fatal(err)
+	}
+	if *group && *follow {
+		fatal(fmt.Errorf("-group cannot be combined with -f"))
+	}
+
+	useColor, err := resolveColor(*color)
+	if err != nil {
+		fatal(err)
+	}
+
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	p := newPrinter(os.Stdout, useColor, *raw)
+	var grouper *traceGrouper
+	if *group {
+		grouper = newTraceGrouper()
+	}
+
+	var mu sync.Mutex
+	emit := func(line string) {
+		e := parseEntry(line)
+		if !f.match(e) {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if grouper != nil {
+			grouper.add(e)
+			return
+		}
+		p.print(e)
+	}
+
+	if err := readAll(ctx, files, *follow, emit); err != nil {
+		fatal(err)
+	}
+
+	if grouper != nil {
+		grouper.flush(p)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "logcat: %v\n", err)
+	os.Exit(1)
+}
+
+// resolveColor turns the -color flag into a yes/no answer
+func resolveColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		fi, err := os.Stdout.Stat()
+		if err != nil {
+			return false, nil
+		}
+		return fi.Mode()&os.ModeCharDevice != 0, nil
+	default:
+		return false, fmt.Errorf("invalid -color value %q (want auto, always or never)", mode)
+	}
+}
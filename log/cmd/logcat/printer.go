@@ -0,0 +1,195 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bytefreezer/goodies/log"
+)
+
+// ANSI escape sequences used when color is enabled
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiDim     = "\x1b[2m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+	ansiMagenta = "\x1b[35m"
+	ansiCyan    = "\x1b[36m"
+	ansiGray    = "\x1b[90m"
+)
+
+const timeLayout = "2006-01-02 15:04:05.000"
+
+// reservedKeys are printed in fixed positions rather than as attributes
+var reservedKeys = map[string]bool{
+	slog.TimeKey:    true,
+	slog.LevelKey:   true,
+	slog.MessageKey: true,
+	slog.SourceKey:  true,
+	log.TraceKey:    true,
+	log.CustomerKey: true,
+}
+
+// printer renders entries either as the original JSON or as a single
+// human-readable line
+type printer struct {
+	w     io.Writer
+	color bool
+	raw   bool
+}
+
+func newPrinter(w io.Writer, color, raw bool) *printer {
+	return &printer{w: w, color: color, raw: raw}
+}
+
+func (p *printer) paint(code, s string) string {
+	if !p.color || s == "" {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+func (p *printer) print(e *entry) {
+	if p.raw || !e.isJSON {
+		fmt.Fprintln(p.w, e.raw)
+		return
+	}
+	fmt.Fprintln(p.w, p.format(e))
+}
+
+func (p *printer) format(e *entry) string {
+	var b strings.Builder
+
+	if !e.time.IsZero() {
+		b.WriteString(p.paint(ansiGray, e.time.Format(timeLayout)))
+		b.WriteByte(' ')
+	}
+	b.WriteString(p.paint(levelColor(e.level), fmt.Sprintf("%-5s", e.levelName)))
+	b.WriteByte(' ')
+	b.WriteString(p.paint(ansiBold, e.msg))
+
+	keys := make([]string, 0, len(e.fields))
+	for k := range e.fields {
+		if !reservedKeys[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString("  ")
+		b.WriteString(p.paint(ansiCyan, k+"="))
+		b.WriteString(quoteIfNeeded(stringValue(e.fields[k])))
+	}
+
+	if e.traceID != "" {
+		b.WriteString("  ")
+		b.WriteString(p.paint(ansiMagenta, log.TraceKey+"="+e.traceID))
+	}
+	if e.customerID != "" {
+		b.WriteString("  ")
+		b.WriteString(p.paint(ansiMagenta, log.CustomerKey+"="+e.customerID))
+	}
+
+	if src := formatSource(e.fields[slog.SourceKey]); src != "" {
+		b.WriteString("  ")
+		b.WriteString(p.paint(ansiDim, src))
+	}
+
+	return b.String()
+}
+
+func levelColor(l slog.Level) string {
+	switch {
+	case l >= log.LevelFatal:
+		return ansiBold + ansiRed
+	case l >= slog.LevelError:
+		return ansiRed
+	case l >= slog.LevelWarn:
+		return ansiYellow
+	case l >= slog.LevelInfo:
+		return ansiGreen
+	default:
+		return ansiGray
+	}
+}
+
+// formatSource renders a slog source object as file:line
+func formatSource(v any) string {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return stringValue(v)
+	}
+	file := stringValue(m["file"])
+	if file == "" {
+		return ""
+	}
+	src := filepath.Base(file)
+	if line := stringValue(m["line"]); line != "" {
+		src += ":" + line
+	}
+	return src
+}
+
+func quoteIfNeeded(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// traceGrouper buffers entries so that every record of a trace is printed
+// together, in order of each trace's first appearance
+type traceGrouper struct {
+	order   []string
+	byTrace map[string][]*entry
+	noTrace []*entry
+}
+
+func newTraceGrouper() *traceGrouper {
+	return &traceGrouper{byTrace: make(map[string][]*entry)}
+}
+
+func (g *traceGrouper) add(e *entry) {
+	if e.traceID == "" {
+		g.noTrace = append(g.noTrace, e)
+		return
+	}
+	if _, ok := g.byTrace[e.traceID]; !ok {
+		g.order = append(g.order, e.traceID)
+	}
+	g.byTrace[e.traceID] = append(g.byTrace[e.traceID], e)
+}
+
+func (g *traceGrouper) flush(p *printer) {
+	for _, id := range g.order {
+		g.printGroup(p, fmt.Sprintf("trace %s", id), g.byTrace[id])
+	}
+	if len(g.noTrace) > 0 {
+		g.printGroup(p, "no trace", g.noTrace)
+	}
+}
+
+func (g *traceGrouper) printGroup(p *printer, title string, entries []*entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].time.Before(entries[j].time)
+	})
+	if !p.raw {
+		header := fmt.Sprintf("── %s (%d records) ──", title, len(entries))
+		fmt.Fprintln(p.w, p.paint(ansiBold, header))
+	}
+	for _, e := range entries {
+		p.print(e)
+	}
+	if !p.raw {
+		fmt.Fprintln(p.w)
+	}
+}
@@ -5,8 +5,10 @@ package log
 
 import (
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"time"
 )
 
 var defaultHandlerOptions = slog.HandlerOptions{AddSource: true, ReplaceAttr: resolveLogLevel}
@@ -14,12 +16,19 @@ var defaultHandlerOptions = slog.HandlerOptions{AddSource: true, ReplaceAttr: re
 var defaultLogger = &Logger{logger: slog.New(slog.NewJSONHandler(os.Stdout, &defaultHandlerOptions)), w: os.Stdout}
 
 func SetMinLogLevel(lev slog.Leveler) {
-	cpo := defaultHandlerOptions
-	cpo.Level = lev
-	newSlog := slog.New(slog.NewJSONHandler(os.Stdout, &cpo))
-	defaultLogger.Lock()
-	defaultLogger.logger = newSlog
-	defaultLogger.Unlock()
+	defaultLogger.SetMinLogLevel(lev)
+}
+
+func SetOutput(w io.Writer) {
+	defaultLogger.SetOutput(w)
+}
+
+func SetLimits(lim Limits) {
+	defaultLogger.SetLimits(lim)
+}
+
+func SetSourceOptions(opts SourceOptions) {
+	defaultLogger.SetSourceOptions(opts)
 }
 
 func WithTrace(traceID string) *Logger {
@@ -33,9 +42,13 @@ func WithCustomer(customerID string) *Logger {
 func With(args ...any) *Logger {
 	defaultLogger.Lock()
 	defer defaultLogger.Unlock()
-	nl := Logger{w: defaultLogger.w}
+	nl := defaultLogger.clone()
 	nl.logger = defaultLogger.logger.With(args...)
-	return &nl
+	return nl
+}
+
+func WithCallerSkip(n int) *Logger {
+	return defaultLogger.WithCallerSkip(n)
 }
 
 func Debug(msg string) {
@@ -113,3 +126,22 @@ func Printf(msg string, args ...any) {
 	msg = fmt.Sprintf(msg, args...)
 	defaultLogger.w.Write([]byte(msg + "\n"))
 }
+
+func SetSlowThreshold(d time.Duration) {
+	defaultLogger.SetSlowThreshold(d)
+}
+
+func StartSpan(name string, args ...any) *Span {
+	return defaultLogger.startSpan(name, args...)
+}
+
+func Timed(name string, args ...any) func() {
+	s := defaultLogger.startSpan(name, args...)
+	return func() { s.end() }
+}
+
+func TimedErr(errp *error, name string, args ...any) func() {
+	s := defaultLogger.startSpan(name, args...)
+	s.errp = errp
+	return func() { s.end() }
+}
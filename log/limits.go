@@ -0,0 +1,271 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"unicode/utf8"
+)
+
+// TruncatedKey is set to true on records that had something cut short
+const TruncatedKey = "truncated"
+
+// Limits caps how much of a record is written, so an accidentally logged
+// response body or schema map can't produce multi-megabyte lines.  Cut
+// strings end in "…(truncated N bytes)" and the record gets truncated=true.
+// A zero field means no limit, and the zero value changes nothing.
+type Limits struct {
+	// MaxMessageBytes caps the message
+	MaxMessageBytes int
+	// MaxAttrBytes caps each attribute value, measured as JSON for maps,
+	// slices and structs
+	MaxAttrBytes int
+	// MaxRecordBytes caps the message plus all attributes.  It is approximate,
+	// as JSON punctuation and the time, level and source aren't counted.
+	// Attributes that don't fit in what is left are cut down or replaced.
+	MaxRecordBytes int
+	// MaxDepth caps how deeply maps, slices and structs are nested
+	MaxDepth int
+	// MaxItems caps the number of elements written per map or slice
+	MaxItems int
+}
+
+// SetLimits sets the size limits for this logger's records
+func (l *Logger) SetLimits(lim Limits) {
+	l.Lock()
+	l.limits = lim
+	l.logger = slog.New(l.newHandler())
+	l.Unlock()
+}
+
+func truncateString(s string, max int) (string, bool) {
+	if max <= 0 || len(s) <= max {
+		return s, false
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + fmt.Sprintf("…(truncated %d bytes)", len(s)-cut), true
+}
+
+// limitHandler applies Limits before handing records to the JSON handler
+type limitHandler struct {
+	next slog.Handler
+	lim  Limits
+	// fixedSize is the size of the attributes added by With
+	fixedSize int
+	// fixedTruncated is set when an attribute added by With was cut
+	fixedTruncated bool
+}
+
+func (h *limitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *limitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	nh := *h
+	limited := make([]slog.Attr, len(attrs))
+	sizes := make([]int, len(attrs))
+	for i, a := range attrs {
+		var cut bool
+		limited[i], sizes[i], cut = h.lim.limitAttr(a)
+		nh.fixedTruncated = nh.fixedTruncated || cut
+	}
+	if h.lim.MaxRecordBytes > 0 {
+		used, cut := fitBudget(limited, sizes, h.lim.MaxRecordBytes-h.fixedSize)
+		nh.fixedSize += used
+		nh.fixedTruncated = nh.fixedTruncated || cut
+	}
+	nh.next = h.next.WithAttrs(limited)
+	return &nh
+}
+
+func (h *limitHandler) WithGroup(name string) slog.Handler {
+	nh := *h
+	nh.next = h.next.WithGroup(name)
+	return &nh
+}
+
+func (h *limitHandler) Handle(ctx context.Context, r slog.Record) error {
+	truncated := h.fixedTruncated
+
+	msg, cut := truncateString(r.Message, h.lim.MaxMessageBytes)
+	truncated = truncated || cut
+
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	sizes := make([]int, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		la, size, cut := h.lim.limitAttr(a)
+		attrs = append(attrs, la)
+		sizes = append(sizes, size)
+		truncated = truncated || cut
+		return true
+	})
+
+	if h.lim.MaxRecordBytes > 0 {
+		msg, cut = truncateString(msg, h.lim.MaxRecordBytes)
+		truncated = truncated || cut
+
+		_, cut = fitBudget(attrs, sizes, h.lim.MaxRecordBytes-len(msg)-h.fixedSize)
+		truncated = truncated || cut
+	}
+
+	nr := slog.NewRecord(r.Time, r.Level, msg, r.PC)
+	nr.AddAttrs(attrs...)
+	if truncated {
+		nr.AddAttrs(slog.Bool(TruncatedKey, true))
+	}
+	return h.next.Handle(ctx, nr)
+}
+
+// fitBudget keeps attributes in order while they fit in budget bytes, then
+// cuts down or replaces the rest.  It returns the bytes used.
+func fitBudget(attrs []slog.Attr, sizes []int, budget int) (int, bool) {
+	var used int
+	var truncated bool
+	for i, a := range attrs {
+		if sizes[i] <= budget {
+			budget -= sizes[i]
+			used += sizes[i]
+			continue
+		}
+		attrs[i] = fitAttr(a, sizes[i], budget)
+		budget = 0
+		used += len(attrs[i].Key) + len(attrs[i].Value.String())
+		truncated = true
+	}
+	return used, truncated
+}
+
+// fitAttr cuts a string attribute down to budget bytes, and replaces anything
+// else that doesn't fit with a marker
+func fitAttr(a slog.Attr, size, budget int) slog.Attr {
+	budget -= len(a.Key)
+	if a.Value.Kind() == slog.KindString && budget > 0 {
+		s, _ := truncateString(a.Value.String(), budget)
+		return slog.String(a.Key, s)
+	}
+	return slog.String(a.Key, fmt.Sprintf("…(truncated %d bytes)", size-len(a.Key)))
+}
+
+// limitAttr applies the per-attribute limits, returning the limited attribute,
+// its approximate size and whether anything was cut
+func (lim Limits) limitAttr(a slog.Attr) (slog.Attr, int, bool) {
+	v := a.Value.Resolve()
+	size := len(a.Key)
+
+	switch v.Kind() {
+	case slog.KindString:
+		s, cut := truncateString(v.String(), lim.MaxAttrBytes)
+		return slog.String(a.Key, s), size + len(s), cut
+
+	case slog.KindGroup:
+		var truncated bool
+		group := v.Group()
+		limited := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			var gsize int
+			var cut bool
+			limited[i], gsize, cut = lim.limitAttr(ga)
+			size += gsize
+			truncated = truncated || cut
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(limited...)}, size, truncated
+
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			s, cut := truncateString(err.Error(), lim.MaxAttrBytes)
+			return slog.String(a.Key, s), size + len(s), cut
+		}
+		if lim.MaxAttrBytes == 0 && lim.MaxRecordBytes == 0 && lim.MaxDepth == 0 && lim.MaxItems == 0 {
+			return slog.Attr{Key: a.Key, Value: v}, size, false
+		}
+		return lim.limitAny(a.Key, v.Any())
+
+	default:
+		return slog.Attr{Key: a.Key, Value: v}, size + len(v.String()), false
+	}
+}
+
+// limitAny limits maps, slices and structs by way of their JSON form
+func (lim Limits) limitAny(key string, x any) (slog.Attr, int, bool) {
+	b, err := json.Marshal(x)
+	if err != nil {
+		// Leave it to the handler to report
+		return slog.Any(key, x), len(key), false
+	}
+
+	var truncated bool
+	if lim.MaxDepth > 0 || lim.MaxItems > 0 {
+		var generic any
+		if err := json.Unmarshal(b, &generic); err == nil {
+			generic, truncated = lim.limitValue(generic, 1)
+			if truncated {
+				x = generic
+				b, _ = json.Marshal(generic)
+			}
+		}
+	}
+
+	if lim.MaxAttrBytes > 0 && len(b) > lim.MaxAttrBytes {
+		s, _ := truncateString(string(b), lim.MaxAttrBytes)
+		return slog.String(key, s), len(key) + len(s), true
+	}
+	return slog.Any(key, x), len(key) + len(b), truncated
+}
+
+// limitValue applies MaxDepth and MaxItems to a decoded JSON value
+func (lim Limits) limitValue(x any, depth int) (any, bool) {
+	switch t := x.(type) {
+	case map[string]any:
+		if lim.MaxDepth > 0 && depth > lim.MaxDepth {
+			return fmt.Sprintf("…(truncated map of %d items)", len(t)), true
+		}
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var truncated bool
+		out := make(map[string]any, len(t))
+		for i, k := range keys {
+			if lim.MaxItems > 0 && i >= lim.MaxItems {
+				out["…"] = fmt.Sprintf("(truncated %d items)", len(keys)-i)
+				truncated = true
+				break
+			}
+			v, cut := lim.limitValue(t[k], depth+1)
+			out[k] = v
+			truncated = truncated || cut
+		}
+		return out, truncated
+
+	case []any:
+		if lim.MaxDepth > 0 && depth > lim.MaxDepth {
+			return fmt.Sprintf("…(truncated list of %d items)", len(t)), true
+		}
+		var truncated bool
+		out := make([]any, 0, len(t))
+		for i, e := range t {
+			if lim.MaxItems > 0 && i >= lim.MaxItems {
+				out = append(out, fmt.Sprintf("…(truncated %d items)", len(t)-i))
+				truncated = true
+				break
+			}
+			v, cut := lim.limitValue(e, depth+1)
+			out = append(out, v)
+			truncated = truncated || cut
+		}
+		return out, truncated
+
+	default:
+		return x, false
+	}
+}
@@ -15,23 +15,39 @@ import (
 )
 
 const (
-	TraceKey    = "traceId"
-	CustomerKey = "customerId"
+	TraceKey      = "traceId"
+	CustomerKey   = "customerId"
+	SpanKey       = "spanId"
+	ParentSpanKey = "parentSpanId"
+	DurationKey   = "duration_ms"
 )
 
 type Record struct {
-	Time       time.Time    `json:"time"`
-	Level      string       `json:"level"`
-	Source     *slog.Source `json:"source"`
-	Message    string       `json:"msg"`
-	TraceID    string       `json:"traceId"`
-	CustomerID string       `json:"customerId"`
+	Time         time.Time    `json:"time"`
+	Level        string       `json:"level"`
+	Source       *slog.Source `json:"source"`
+	Message      string       `json:"msg"`
+	TraceID      string       `json:"traceId"`
+	CustomerID   string       `json:"customerId"`
+	SpanID       string       `json:"spanId"`
+	ParentSpanID string       `json:"parentSpanId"`
 }
 
 type Logger struct {
 	sync.Mutex
 	logger *slog.Logger
 	w      io.Writer
+
+	// handler settings, kept so the handler can be rebuilt when one changes
+	level      slog.Leveler
+	source     SourceOptions
+	limits     Limits
+	callerSkip int
+
+	// span state, see span.go
+	spanID        string
+	parentSpanID  string
+	slowThreshold time.Duration
 }
 
 func New(w io.Writer) *Logger {
@@ -49,9 +65,46 @@ func (l *Logger) WithCustomer(customerID string) *Logger {
 func (l *Logger) With(args ...any) *Logger {
 	l.Lock()
 	defer l.Unlock()
-	nl := Logger{w: l.w}
+	nl := l.clone()
 	nl.logger = l.logger.With(args...)
-	return &nl
+	return nl
+}
+
+// Slog returns the logger's underlying *slog.Logger, for libraries that log
+// through log/slog.  It is the handler as configured now; later SetOutput or
+// SetMinLogLevel calls don't reach it.
+func (l *Logger) Slog() *slog.Logger {
+	l.Lock()
+	defer l.Unlock()
+	return l.logger
+}
+
+// clone copies the logger's settings into a new logger sharing its handler.
+// Must be called with l locked.
+func (l *Logger) clone() *Logger {
+	return &Logger{
+		logger:        l.logger,
+		w:             l.w,
+		level:         l.level,
+		source:        l.source,
+		limits:        l.limits,
+		callerSkip:    l.callerSkip,
+		spanID:        l.spanID,
+		parentSpanID:  l.parentSpanID,
+		slowThreshold: l.slowThreshold,
+	}
+}
+
+// newHandler builds a JSON handler from the logger's settings
+func (l *Logger) newHandler() slog.Handler {
+	cpo := defaultHandlerOptions
+	cpo.Level = l.level
+	cpo.ReplaceAttr = l.source.replaceAttr()
+	var h slog.Handler = slog.NewJSONHandler(l.w, &cpo)
+	if l.limits != (Limits{}) {
+		h = &limitHandler{next: h, lim: l.limits}
+	}
+	return h
 }
 
 func (l *Logger) Debug(msg string) {
@@ -130,27 +183,49 @@ func (l *Logger) Printf(msg string, args ...any) {
 	l.w.Write([]byte(msg + "\n"))
 }
 
+// SetOutput changes where the logger writes, for example to also send records
+// to an exporter with io.MultiWriter
+func (l *Logger) SetOutput(w io.Writer) {
+	l.Lock()
+	l.w = w
+	l.logger = slog.New(l.newHandler())
+	l.Unlock()
+}
+
 func (l *Logger) SetMinLogLevel(lev slog.Leveler) {
-	cpo := defaultHandlerOptions
-	cpo.Level = lev
-	newSlog := slog.New(slog.NewJSONHandler(l.w, &cpo))
 	l.Lock()
-	l.logger = newSlog
+	l.level = lev
+	l.logger = slog.New(l.newHandler())
 	l.Unlock()
 }
 
 func (l *Logger) log(level slog.Level, msg string, args ...any) {
+	l.logDepth(1, level, msg, args...)
+}
+
+// logDepth logs with the source set to the user's code, which is depth frames
+// above logDepth's caller
+func (l *Logger) logDepth(depth int, level slog.Level, msg string, args ...any) {
 	ctx := context.Background()
 	if !l.logger.Enabled(ctx, level) {
 		return
 	}
 	var pc uintptr
-	var pcs [1]uintptr
-	// skip [runtime.Callers, this function, this function's caller]
-	runtime.Callers(3, pcs[:])
-	pc = pcs[0]
+	if l.source.sourceEnabled(level) {
+		var pcs [1]uintptr
+		// skip [runtime.Callers, this function, this function's caller] plus
+		// depth more, plus any frames the user asked to skip
+		runtime.Callers(3+depth+l.callerSkip, pcs[:])
+		pc = pcs[0]
+	}
 
 	r := slog.NewRecord(time.Now(), level, msg, pc)
+	if l.spanID != "" {
+		r.AddAttrs(slog.String(SpanKey, l.spanID))
+	}
+	if l.parentSpanID != "" {
+		r.AddAttrs(slog.String(ParentSpanKey, l.parentSpanID))
+	}
 	r.Add(args...)
 	_ = l.logger.Handler().Handle(ctx, r)
 }
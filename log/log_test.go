@@ -7,7 +7,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"io"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestLoggerShouldntPrint(t *testing.T) {
@@ -83,3 +87,233 @@ func TestPrintf(t *testing.T) {
 func TestPrintToScreen(t *testing.T) {
 	Printf("hello %s", "user")
 }
+
+func TestTimedNesting(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b)
+	span := l.StartSpan("compact partition", "partition", "p1")
+	child := span.Logger().StartSpan("write file")
+	child.End()
+	child.End()
+	span.End()
+
+	lines := bytes.Split(bytes.TrimSpace(b.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 finish records, got %d: %s", len(lines), b.String())
+	}
+
+	var inner, outer map[string]any
+	if err := json.Unmarshal(lines[0], &inner); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(lines[1], &outer); err != nil {
+		t.Fatal(err)
+	}
+	if inner["msg"] != "write file finished" || inner[ParentSpanKey] != span.ID() || inner[SpanKey] != child.ID() {
+		t.Fatalf("unexpected child record %v", inner)
+	}
+	if outer["partition"] != "p1" || outer[SpanKey] != span.ID() || outer[ParentSpanKey] != nil {
+		t.Fatalf("unexpected parent record %v", outer)
+	}
+	if _, ok := outer[DurationKey].(float64); !ok {
+		t.Fatalf("expected %s in %v", DurationKey, outer)
+	}
+	src := outer["source"].(map[string]any)
+	if !bytes.HasSuffix([]byte(src["file"].(string)), []byte("log_test.go")) {
+		t.Fatalf("expected source in log_test.go, got %v", src["file"])
+	}
+}
+
+func TestTimedSlowAndError(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b)
+	l.SetSlowThreshold(time.Nanosecond)
+
+	func() {
+		defer l.Timed("slow step")()
+		time.Sleep(time.Millisecond)
+	}()
+	rec := &Record{}
+	if err := json.Unmarshal(b.Bytes(), rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Level != "WARN" {
+		t.Fatalf("expected WARN for slow span, got %s", rec.Level)
+	}
+
+	b.Reset()
+	_ = func() (err error) {
+		defer l.TimedErr(&err, "failing step")()
+		return errors.New("disk full")
+	}()
+	var m map[string]any
+	if err := json.Unmarshal(b.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["level"] != "ERROR" || m["error"] != "disk full" {
+		t.Fatalf("expected ERROR with error attached, got %v", m)
+	}
+}
+
+func TestSpanSlowThresholdConcurrent(t *testing.T) {
+	l := New(io.Discard)
+	for i := 0; i < 20; i++ {
+		s := l.StartSpan("step")
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Logger().SetSlowThreshold(time.Millisecond)
+		}()
+		// Let the goroutine get ahead, without synchronising with it
+		time.Sleep(100 * time.Microsecond)
+		s.End()
+		wg.Wait()
+	}
+}
+
+func TestSlog(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b)
+	l.SetMinLogLevel(MinLevelDebug)
+	l.Slog().Debug("from slog", "trace_id", "abc")
+
+	var m map[string]any
+	if err := json.Unmarshal(b.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["msg"] != "from slog" || m["trace_id"] != "abc" || m["level"] != "DEBUG" {
+		t.Fatalf("expected the slog record through this logger's handler, got %v", m)
+	}
+}
+
+func TestSourceOptions(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b)
+	l.SetSourceOptions(SourceOptions{Path: SourcePathBase, OmitFunction: true, MinLevel: MinLevelWarn})
+
+	l.Warn("with source")
+	var m map[string]any
+	if err := json.Unmarshal(b.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	src, ok := m["source"].(map[string]any)
+	if !ok || src["file"] != "log_test.go" || src["function"] != nil {
+		t.Fatalf("expected trimmed source without function, got %v", m["source"])
+	}
+
+	b.Reset()
+	l.Info("without source")
+	m = nil
+	if err := json.Unmarshal(b.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m["source"]; ok {
+		t.Fatalf("expected no source below WARN, got %v", m["source"])
+	}
+}
+
+func TestRelativeSourcePath(t *testing.T) {
+	got := relativePath("/ci/runner/work/goodies/log/cmd/logcat/filter.go", "github.com/bytefreezer/goodies/log/cmd/logcat.(*filter).match")
+	if got != "cmd/logcat/filter.go" {
+		t.Fatalf("expected module-relative source, got %s", got)
+	}
+	got = relativePath("/go/pkg/mod/github.com/other/lib@v1.0.0/util/util.go", "github.com/other/lib/util.Do")
+	if got != "github.com/other/lib/util/util.go" {
+		t.Fatalf("expected import-path style source, got %s", got)
+	}
+	if got := relativePath("/ci/cmd/main.go", "main.main"); got != "main.go" {
+		t.Fatalf("expected base name for package main, got %s", got)
+	}
+}
+
+func TestPackagePathDottedElement(t *testing.T) {
+	cases := map[string]string{
+		"gopkg.in/yaml.v3.(*Decoder).Decode":      "gopkg.in/yaml.v3",
+		"gopkg.in/yaml%2ev3.(*Decoder).Decode":    "gopkg.in/yaml.v3",
+		"gopkg.in/yaml%2ev3.Unmarshal.func1":      "gopkg.in/yaml.v3",
+		"gopkg.in/yaml.v3.Unmarshal":              "gopkg.in/yaml.v3",
+		"gopkg.in/yaml.v3.Unmarshal.func1":        "gopkg.in/yaml.v3",
+		"github.com/other/lib/util.Do":            "github.com/other/lib/util",
+		"github.com/other/lib/util.Do.func1":      "github.com/other/lib/util",
+		"github.com/other/lib/util.(*T).Do.func2": "github.com/other/lib/util",
+		"main.main": "main",
+	}
+	for function, want := range cases {
+		if got := packagePath(function); got != want {
+			t.Errorf("packagePath(%q) = %q, want %q", function, got, want)
+		}
+	}
+}
+
+func logHelper(l *Logger, msg string) {
+	l.WithCallerSkip(1).Error(msg)
+}
+
+func TestWithCallerSkip(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b)
+	logHelper(l, "from helper")
+	rec := &Record{}
+	if err := json.Unmarshal(b.Bytes(), rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Source == nil || rec.Source.Function != "github.com/bytefreezer/goodies/log.TestWithCallerSkip" {
+		t.Fatalf("expected source in the helper's caller, got %+v", rec.Source)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b)
+	l.SetLimits(Limits{MaxMessageBytes: 10, MaxAttrBytes: 100, MaxDepth: 1, MaxItems: 2})
+
+	schema := map[string]any{"a": 1, "b": map[string]any{"nested": true}, "c": 3}
+	l.With("body", strings.Repeat("x", 300), "schema", schema).Error(strings.Repeat("m", 50))
+
+	var m map[string]any
+	if err := json.Unmarshal(b.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["msg"] != strings.Repeat("m", 10)+"…(truncated 40 bytes)" {
+		t.Fatalf("expected truncated message, got %v", m["msg"])
+	}
+	if m["body"] != strings.Repeat("x", 100)+"…(truncated 200 bytes)" {
+		t.Fatalf("expected truncated attribute, got %v", m["body"])
+	}
+	got, ok := m["schema"].(map[string]any)
+	if !ok || len(got) != 3 || got["b"] != "…(truncated map of 1 items)" {
+		t.Fatalf("expected depth and length limited map, got %v", m["schema"])
+	}
+	if m[TruncatedKey] != true {
+		t.Fatalf("expected %s=true, got %v", TruncatedKey, m)
+	}
+}
+
+func TestRecordLimit(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b)
+	l.SetLimits(Limits{MaxRecordBytes: 64})
+
+	l.With("status", 500, "body", strings.Repeat("y", 1000), "after", []int{1, 2, 3}).Error("response")
+	var m map[string]any
+	if err := json.Unmarshal(b.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := m["body"].(string); strings.Count(body, "y") > 64 || !strings.Contains(body, "truncated") {
+		t.Fatalf("expected body cut to the record budget, got %q", body)
+	}
+	if m["after"] != "…(truncated 7 bytes)" {
+		t.Fatalf("expected attribute past the budget replaced, got %v", m["after"])
+	}
+
+	b.Reset()
+	l.With("status", 200).Error("small")
+	m = nil
+	if err := json.Unmarshal(b.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m[TruncatedKey]; ok {
+		t.Fatalf("expected no truncation flag on a small record, got %v", m)
+	}
+}
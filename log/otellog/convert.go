@@ -0,0 +1,177 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package otellog
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bytefreezer/goodies/log"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+)
+
+// convertLine turns one line written by the log package into an OTLP log
+// record.  Lines that aren't JSON, such as Print output, become records with
+// just a body.
+func convertLine(line []byte, observed time.Time) *logspb.LogRecord {
+	rec := &logspb.LogRecord{ObservedTimeUnixNano: uint64(observed.UnixNano())}
+
+	fields := map[string]any{}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		rec.Body = stringValue(string(line))
+		return rec
+	}
+
+	if s, ok := fields[slog.TimeKey].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			rec.TimeUnixNano = uint64(t.UnixNano())
+		}
+	}
+	if s, ok := fields[slog.LevelKey].(string); ok {
+		rec.SeverityText = s
+		rec.SeverityNumber = severity(s)
+	}
+	if s, ok := fields[slog.MessageKey].(string); ok {
+		rec.Body = stringValue(s)
+	}
+
+	// Prefer the OpenTelemetry IDs, falling back to a traceId that happens to
+	// be a W3C trace ID
+	if id := decodeID(fields[TraceIDKey], 16); id != nil {
+		rec.TraceId = id
+	} else if id := decodeID(fields[log.TraceKey], 16); id != nil {
+		rec.TraceId = id
+	}
+	if id := decodeID(fields[SpanIDKey], 8); id != nil {
+		rec.SpanId = id
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		switch k {
+		case slog.TimeKey, slog.LevelKey, slog.MessageKey, TraceIDKey, SpanIDKey:
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if k == slog.SourceKey {
+			rec.Attributes = append(rec.Attributes, sourceAttributes(fields[k])...)
+			continue
+		}
+		rec.Attributes = append(rec.Attributes, &commonpb.KeyValue{Key: k, Value: anyValue(fields[k])})
+	}
+
+	return rec
+}
+
+// severity maps a slog level name, including offsets such as "INFO+2" and the
+// log package's FATAL, onto the OpenTelemetry severity numbers
+func severity(name string) logspb.SeverityNumber {
+	var level slog.Level
+	if strings.EqualFold(name, "FATAL") {
+		level = log.LevelFatal
+	} else if err := level.UnmarshalText([]byte(name)); err != nil {
+		return logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED
+	}
+
+	// Each OpenTelemetry severity has four steps, and slog levels are four
+	// apart, so DEBUG(-4) lands on DEBUG(5) and INFO(0) on INFO(9)
+	n := int(level) + 9
+	switch {
+	case n < int(logspb.SeverityNumber_SEVERITY_NUMBER_TRACE):
+		n = int(logspb.SeverityNumber_SEVERITY_NUMBER_TRACE)
+	case n > int(logspb.SeverityNumber_SEVERITY_NUMBER_FATAL4):
+		n = int(logspb.SeverityNumber_SEVERITY_NUMBER_FATAL4)
+	}
+	return logspb.SeverityNumber(n)
+}
+
+// sourceAttributes maps the slog source onto the code.* semantic conventions
+func sourceAttributes(v any) []*commonpb.KeyValue {
+	src, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	var attrs []*commonpb.KeyValue
+	if s, ok := src["function"].(string); ok && s != "" {
+		attrs = append(attrs, &commonpb.KeyValue{Key: "code.function", Value: stringValue(s)})
+	}
+	if s, ok := src["file"].(string); ok && s != "" {
+		attrs = append(attrs, &commonpb.KeyValue{Key: "code.filepath", Value: stringValue(s)})
+	}
+	if n, ok := src["line"].(json.Number); ok {
+		if i, err := n.Int64(); err == nil && i > 0 {
+			attrs = append(attrs, &commonpb.KeyValue{Key: "code.lineno", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: i}}})
+		}
+	}
+	return attrs
+}
+
+func decodeID(v any, size int) []byte {
+	s, ok := v.(string)
+	if !ok || len(s) != size*2 {
+		return nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil
+	}
+	for _, c := range b {
+		if c != 0 {
+			return b
+		}
+	}
+	return nil
+}
+
+func stringValue(s string) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+}
+
+// anyValue converts a decoded JSON value into an OTLP attribute value
+func anyValue(v any) *commonpb.AnyValue {
+	switch t := v.(type) {
+	case string:
+		return stringValue(t)
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: t}}
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: i}}
+		}
+		f, _ := t.Float64()
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: f}}
+	case []any:
+		values := make([]*commonpb.AnyValue, len(t))
+		for i, e := range t {
+			values[i] = anyValue(e)
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		kvs := make([]*commonpb.KeyValue, len(keys))
+		for i, k := range keys {
+			kvs[i] = &commonpb.KeyValue{Key: k, Value: anyValue(t[k])}
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: kvs}}}
+	default:
+		// JSON null
+		return &commonpb.AnyValue{}
+	}
+}
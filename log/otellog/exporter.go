@@ -0,0 +1,298 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package otellog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// scopeName identifies the log package as the instrumentation scope
+const scopeName = "github.com/bytefreezer/goodies/log"
+
+// ExporterConfig configures an Exporter
+type ExporterConfig struct {
+	// Endpoint is the collector's OTLP/HTTP address, e.g. http://otel-collector:4318.
+	// /v1/logs is appended unless the URL already has a path.
+	Endpoint string
+	// Headers are sent with every export request, e.g. for authentication
+	Headers map[string]string
+	// ServiceName sets the service.name resource attribute
+	ServiceName string
+	// ResourceAttributes are added to the resource, e.g. service.instance.id
+	ResourceAttributes map[string]string
+	// BatchSize is the number of records sent per request (default 512)
+	BatchSize int
+	// MaxQueueSize is the number of records buffered before new ones are
+	// dropped (default 4096)
+	MaxQueueSize int
+	// FlushInterval is the longest a record waits before being sent (default 5s)
+	FlushInterval time.Duration
+	// TimeoutSeconds bounds each export request (default 10)
+	TimeoutSeconds int
+	// HTTPClient overrides the client used to reach the collector
+	HTTPClient *http.Client
+}
+
+// Exporter sends log records to an OpenTelemetry collector as OTLP logs over
+// HTTP/protobuf.  It is an io.Writer, so it plugs straight into the log package:
+//
+//	exp, err := otellog.NewExporter(otellog.ExporterConfig{Endpoint: "http://otel-collector:4318", ServiceName: "packer"})
+//	log.SetOutput(io.MultiWriter(os.Stdout, exp))
+//	defer exp.Shutdown(context.Background())
+//
+// Writes never block on the network; records are batched and sent in the
+// background.
+type Exporter struct {
+	url        string
+	headers    map[string]string
+	resource   *resourcepb.Resource
+	httpClient *http.Client
+	batchSize  int
+	maxQueue   int
+	interval   time.Duration
+
+	mu      sync.Mutex
+	queue   []*logspb.LogRecord
+	closed  bool
+	dropped atomic.Int64
+
+	kick     chan struct{}
+	stopChan chan struct{}
+	done     chan struct{}
+	sendMu   sync.Mutex
+	errFunc  func(error)
+}
+
+// NewExporter creates an exporter and starts its background sender
+func NewExporter(config ExporterConfig) (*Exporter, error) {
+	if config.Endpoint == "" {
+		return nil, errors.New("otellog: endpoint is required")
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 512
+	}
+	if config.MaxQueueSize <= 0 {
+		config.MaxQueueSize = 4096
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.TimeoutSeconds == 0 {
+		config.TimeoutSeconds = 10
+	}
+
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("otellog: invalid endpoint %q", config.Endpoint)
+	}
+	if strings.Trim(endpoint.Path, "/") == "" {
+		endpoint.Path = "/v1/logs"
+	}
+
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: time.Duration(config.TimeoutSeconds) * time.Second}
+	}
+
+	var attrs []*commonpb.KeyValue
+	if config.ServiceName != "" {
+		attrs = append(attrs, &commonpb.KeyValue{Key: "service.name", Value: stringValue(config.ServiceName)})
+	}
+	for k, v := range config.ResourceAttributes {
+		attrs = append(attrs, &commonpb.KeyValue{Key: k, Value: stringValue(v)})
+	}
+
+	e := &Exporter{
+		url:        endpoint.String(),
+		headers:    config.Headers,
+		resource:   &resourcepb.Resource{Attributes: attrs},
+		httpClient: httpClient,
+		batchSize:  config.BatchSize,
+		maxQueue:   config.MaxQueueSize,
+		interval:   config.FlushInterval,
+		kick:       make(chan struct{}, 1),
+		stopChan:   make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go e.run()
+
+	return e, nil
+}
+
+// SetErrorFunc sets a function called when a background export fails.  If not
+// set, errors are discarded.  It must not log through an exporter-backed logger.
+func (e *Exporter) SetErrorFunc(f func(error)) {
+	e.mu.Lock()
+	e.errFunc = f
+	e.mu.Unlock()
+}
+
+// Dropped returns how many records were discarded because the queue was full
+func (e *Exporter) Dropped() int64 {
+	return e.dropped.Load()
+}
+
+// Write queues the records in p, one per line.  It always reports success so a
+// collector outage never breaks local logging.
+func (e *Exporter) Write(p []byte) (int, error) {
+	now := time.Now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return len(p), nil
+	}
+
+	for _, line := range bytes.Split(p, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if len(e.queue) >= e.maxQueue {
+			e.dropped.Add(1)
+			continue
+		}
+		e.queue = append(e.queue, convertLine(line, now))
+	}
+
+	if len(e.queue) >= e.batchSize {
+		select {
+		case e.kick <- struct{}{}:
+		default:
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush sends everything queued so far
+func (e *Exporter) Flush(ctx context.Context) error {
+	var errs []error
+	for {
+		batch := e.take()
+		if len(batch) == 0 {
+			return errors.Join(errs...)
+		}
+		if err := e.send(ctx, batch); err != nil {
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				return errors.Join(errs...)
+			}
+		}
+	}
+}
+
+// Shutdown stops the background sender and flushes what is left.  Records
+// written afterwards are ignored.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.mu.Unlock()
+
+	close(e.stopChan)
+	<-e.done
+
+	return e.Flush(ctx)
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.stopChan:
+			return
+		case <-ticker.C:
+		case <-e.kick:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), e.interval+e.httpClient.Timeout)
+		if err := e.Flush(ctx); err != nil {
+			e.mu.Lock()
+			f := e.errFunc
+			e.mu.Unlock()
+			if f != nil {
+				f(err)
+			}
+		}
+		cancel()
+	}
+}
+
+// take removes up to one batch from the queue
+func (e *Exporter) take() []*logspb.LogRecord {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	n := min(len(e.queue), e.batchSize)
+	if n == 0 {
+		return nil
+	}
+	batch := e.queue[:n:n]
+	e.queue = e.queue[n:]
+	return batch
+}
+
+func (e *Exporter) send(ctx context.Context, batch []*logspb.LogRecord) error {
+	// Keep requests in order when Flush and the background sender overlap
+	e.sendMu.Lock()
+	defer e.sendMu.Unlock()
+
+	body, err := proto.Marshal(&collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: e.resource,
+			ScopeLogs: []*logspb.ScopeLogs{{
+				Scope:      &commonpb.InstrumentationScope{Name: scopeName},
+				LogRecords: batch,
+			}},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("otellog: failed to marshal export request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("otellog: failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("otellog: export failed: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("otellog: export failed (status %d): %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
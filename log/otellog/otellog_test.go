@@ -0,0 +1,150 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package otellog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/bytefreezer/goodies/log"
+	"go.opentelemetry.io/otel/trace"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	testTraceID = trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+	testSpanID  = trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}
+)
+
+func spanContext() context.Context {
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: testTraceID, SpanID: testSpanID, TraceFlags: trace.FlagsSampled})
+	return trace.ContextWithSpanContext(context.Background(), sc)
+}
+
+// otlpStub is a minimal OTLP/HTTP logs receiver
+type otlpStub struct {
+	mu      sync.Mutex
+	records []*logspb.LogRecord
+	service string
+}
+
+func (s *otlpStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/logs" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var req collogspb.ExportLogsServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rl := range req.ResourceLogs {
+		for _, kv := range rl.Resource.GetAttributes() {
+			if kv.Key == "service.name" {
+				s.service = kv.Value.GetStringValue()
+			}
+		}
+		for _, sl := range rl.ScopeLogs {
+			s.records = append(s.records, sl.LogRecords...)
+		}
+	}
+
+	out, _ := proto.Marshal(&collogspb.ExportLogsServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(out)
+}
+
+func TestWithSpan(t *testing.T) {
+	var b bytes.Buffer
+	l := log.New(&b)
+	WithSpan(spanContext(), l).Error("boom")
+
+	var m map[string]any
+	if err := json.Unmarshal(b.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m[TraceIDKey] != testTraceID.String() || m[log.TraceKey] != testTraceID.String() || m[SpanIDKey] != testSpanID.String() {
+		t.Fatalf("expected span correlation attributes, got %v", m)
+	}
+
+	if WithSpan(context.Background(), l) != l {
+		t.Fatal("expected logger unchanged without an active span")
+	}
+}
+
+func TestExporter(t *testing.T) {
+	stub := &otlpStub{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	exp, err := NewExporter(ExporterConfig{Endpoint: srv.URL, ServiceName: "packer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := log.New(exp)
+	WithSpan(spanContext(), l).With("partition", "p1", "rows", 42).Warn("slow compaction")
+	l.Print("plain line")
+
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.records) != 2 {
+		t.Fatalf("expected 2 exported records, got %d", len(stub.records))
+	}
+	if stub.service != "packer" {
+		t.Fatalf("expected service.name packer, got %q", stub.service)
+	}
+
+	rec := stub.records[0]
+	if rec.Body.GetStringValue() != "slow compaction" || rec.SeverityNumber != logspb.SeverityNumber_SEVERITY_NUMBER_WARN {
+		t.Fatalf("unexpected record %v", rec)
+	}
+	if !bytes.Equal(rec.TraceId, testTraceID[:]) || !bytes.Equal(rec.SpanId, testSpanID[:]) {
+		t.Fatalf("expected trace and span IDs on the record, got %x %x", rec.TraceId, rec.SpanId)
+	}
+	attrs := map[string]string{}
+	for _, kv := range rec.Attributes {
+		attrs[kv.Key] = kv.Value.String()
+	}
+	for _, k := range []string{"partition", "rows", "code.function", "code.lineno"} {
+		if _, ok := attrs[k]; !ok {
+			t.Fatalf("expected attribute %s, got %v", k, attrs)
+		}
+	}
+
+	if stub.records[1].Body.GetStringValue() != "plain line" {
+		t.Fatalf("expected plain line body, got %v", stub.records[1].Body)
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	tests := map[string]logspb.SeverityNumber{
+		"DEBUG":  logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG,
+		"INFO":   logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+		"INFO+2": logspb.SeverityNumber_SEVERITY_NUMBER_INFO3,
+		"ERROR":  logspb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+		"FATAL":  logspb.SeverityNumber_SEVERITY_NUMBER_FATAL,
+	}
+	for name, want := range tests {
+		if got := severity(name); got != want {
+			t.Fatalf("severity(%s): expected %v, got %v", name, want, got)
+		}
+	}
+}
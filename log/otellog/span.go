@@ -0,0 +1,46 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+// Package otellog connects the goodies log package to OpenTelemetry.  It tags
+// records with the active span from a context.Context, and exports records
+// to an OTLP collector as OTLP logs over HTTP/protobuf.
+//
+// It lives in its own module so services that don't use OpenTelemetry don't
+// pull in its dependencies.
+package otellog
+
+import (
+	"context"
+
+	"github.com/bytefreezer/goodies/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TraceIDKey = "trace_id"
+	SpanIDKey  = "span_id"
+)
+
+// WithSpan returns a logger that tags its records with the trace and span of
+// the span active in ctx.  The trace ID is also written to log.TraceKey, so
+// logcat -trace and anything else keyed on traceId keeps working; use WithSpan
+// in place of WithTrace rather than as well as it.  If ctx has no valid span,
+// l is returned unchanged.
+func WithSpan(ctx context.Context, l *log.Logger) *log.Logger {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return l
+	}
+	traceID := sc.TraceID().String()
+	return l.With(log.TraceKey, traceID, TraceIDKey, traceID, SpanIDKey, sc.SpanID().String())
+}
+
+// FromContext is WithSpan for the default logger
+func FromContext(ctx context.Context) *log.Logger {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return log.With()
+	}
+	traceID := sc.TraceID().String()
+	return log.With(log.TraceKey, traceID, TraceIDKey, traceID, SpanIDKey, sc.SpanID().String())
+}
@@ -0,0 +1,179 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package log
+
+import (
+	"log/slog"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// SourcePath controls how the file in a record's source is written
+type SourcePath int
+
+const (
+	// SourcePathFull writes the file path as compiled in, which is the default
+	SourcePathFull SourcePath = iota
+	// SourcePathRelative writes the path relative to its module, for example
+	// internal/packer/compact.go, or the import path for files from other modules
+	SourcePathRelative
+	// SourcePathBase writes only the file name, for example compact.go
+	SourcePathBase
+)
+
+// SourceOptions controls how the source location is rendered.  The zero value
+// keeps the default: full path, function name included, on every record.
+type SourceOptions struct {
+	Path SourcePath
+	// OmitFunction drops the function name from the source
+	OmitFunction bool
+	// MinLevel, when set, leaves the source off records below this level
+	MinLevel slog.Leveler
+}
+
+// SetSourceOptions changes how this logger renders source locations
+func (l *Logger) SetSourceOptions(opts SourceOptions) {
+	l.Lock()
+	l.source = opts
+	l.logger = slog.New(l.newHandler())
+	l.Unlock()
+}
+
+// WithCallerSkip returns a logger that reports the source location n frames
+// further up the stack.  Use it when wrapping the logger in your own helper
+// functions, so records point at the helper's caller rather than the helper.
+func (l *Logger) WithCallerSkip(n int) *Logger {
+	l.Lock()
+	defer l.Unlock()
+	nl := l.clone()
+	nl.callerSkip += n
+	return nl
+}
+
+// sourceEnabled reports whether records at level should carry a source
+func (o SourceOptions) sourceEnabled(level slog.Level) bool {
+	return o.MinLevel == nil || level >= o.MinLevel.Level()
+}
+
+// replaceAttr returns the handler's ReplaceAttr function for these options
+func (o SourceOptions) replaceAttr() func([]string, slog.Attr) slog.Attr {
+	if o.Path == SourcePathFull && !o.OmitFunction && o.MinLevel == nil {
+		return resolveLogLevel
+	}
+
+	return func(groups []string, a slog.Attr) slog.Attr {
+		a = resolveLogLevel(groups, a)
+		if len(groups) > 0 || a.Key != slog.SourceKey {
+			return a
+		}
+		src, ok := a.Value.Any().(*slog.Source)
+		if !ok {
+			return a
+		}
+		if src.File == "" {
+			// No caller was recorded, e.g. the level is below MinLevel
+			return slog.Attr{}
+		}
+
+		attrs := make([]any, 0, 3)
+		if !o.OmitFunction {
+			attrs = append(attrs, slog.String("function", src.Function))
+		}
+		attrs = append(attrs,
+			slog.String("file", o.trimPath(src.File, src.Function)),
+			slog.Int("line", src.Line),
+		)
+		return slog.Group(slog.SourceKey, attrs...)
+	}
+}
+
+func (o SourceOptions) trimPath(file, function string) string {
+	switch o.Path {
+	case SourcePathBase:
+		return filepath.Base(file)
+	case SourcePathRelative:
+		return relativePath(file, function)
+	default:
+		return file
+	}
+}
+
+// relativePath rebuilds a file's path from its package's import path, taken
+// from the function name, and strips the main module's path from the front.
+// Files in package main fall back to their base name.
+func relativePath(file, function string) string {
+	base := filepath.Base(file)
+	pkg := packagePath(function)
+	if pkg == "" || pkg == "main" {
+		return base
+	}
+
+	if mod := mainModulePath(); mod != "" {
+		if pkg == mod {
+			return base
+		}
+		if rest, ok := strings.CutPrefix(pkg, mod+"/"); ok {
+			return rest + "/" + base
+		}
+	}
+	return pkg + "/" + base
+}
+
+// packagePath extracts the import path from a fully qualified function name
+// such as github.com/org/repo/pkg.(*Type).Method. The last path element may
+// itself contain dots, as in gopkg.in/yaml.v3, so the function and receiver
+// are stripped from the right.
+func packagePath(function string) string {
+	slash := strings.LastIndex(function, "/")
+	dir, tail := function[:slash+1], function[slash+1:]
+
+	// The runtime escapes dots in the last path element, as in
+	// gopkg.in/yaml%2ev3.(*Decoder).Decode, so there the first dot ends it
+	if strings.Contains(tail, "%2e") {
+		dot := strings.Index(tail, ".")
+		if dot < 0 {
+			return ""
+		}
+		return dir + strings.ReplaceAll(tail[:dot], "%2e", ".")
+	}
+
+	// A method on a pointer receiver: pkg.(*Type).Method
+	if i := strings.Index(tail, ".("); i >= 0 {
+		return dir + tail[:i]
+	}
+
+	// pkg.Func, pkg.Type.Method or pkg.Func.func1, where pkg may end in a
+	// version suffix such as .v3
+	parts := strings.Split(tail, ".")
+	if len(parts) < 2 {
+		return ""
+	}
+	n := 1
+	for n < len(parts)-1 && isVersionSuffix(parts[n]) {
+		n++
+	}
+	return dir + strings.Join(parts[:n], ".")
+}
+
+// isVersionSuffix reports whether s looks like the v3 in gopkg.in/yaml.v3
+func isVersionSuffix(s string) bool {
+	if len(s) < 2 || s[0] != 'v' {
+		return false
+	}
+	for _, r := range s[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+var mainModulePath = sync.OnceValue(func() string {
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		return bi.Main.Path
+	}
+	return ""
+})
@@ -0,0 +1,133 @@
+// Licensed under Elastic License 2.0
+// See LICENSE.txt for details
+
+package log
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Span times an operation.  Starting a span logs "<name> started" at DEBUG, and
+// ending it logs "<name> finished" with the elapsed time in duration_ms.  The
+// finish record is INFO, WARN if the logger's slow threshold was exceeded, or
+// ERROR if an error was captured.
+//
+// Every span gets its own spanId.  Logging through Span.Logger(), including
+// starting further spans from it, tags records with that spanId and the
+// parent's, so nested steps can be pieced back together.
+type Span struct {
+	logger *Logger
+	name   string
+	args   []any
+	start  time.Time
+	errp   *error
+	ended  atomic.Bool
+}
+
+// StartSpan starts timing an operation.  Call End on the returned span when the
+// operation is done.
+func (l *Logger) StartSpan(name string, args ...any) *Span {
+	return l.startSpan(name, args...)
+}
+
+// Timed starts a span and returns the function that ends it, for use as
+//
+//	defer logger.Timed("compact partition", "partition", p)()
+func (l *Logger) Timed(name string, args ...any) func() {
+	s := l.startSpan(name, args...)
+	return func() { s.end() }
+}
+
+// TimedErr is Timed for functions with a named error result.  The error errp
+// points to when the returned function runs is attached to the finish record.
+//
+//	func compact(p string) (err error) {
+//		defer logger.TimedErr(&err, "compact partition", "partition", p)()
+func (l *Logger) TimedErr(errp *error, name string, args ...any) func() {
+	s := l.startSpan(name, args...)
+	s.errp = errp
+	return func() { s.end() }
+}
+
+// SetSlowThreshold sets how long a span may take before its finish record is
+// logged at WARN.  Zero, the default, disables the check.  Loggers created
+// from this one afterwards inherit the threshold.
+func (l *Logger) SetSlowThreshold(d time.Duration) {
+	l.Lock()
+	l.slowThreshold = d
+	l.Unlock()
+}
+
+func (l *Logger) startSpan(name string, args ...any) *Span {
+	l.Lock()
+	child := l.clone()
+	child.parentSpanID = l.spanID
+	child.spanID = newSpanID()
+	l.Unlock()
+
+	s := &Span{logger: child, name: name, args: args, start: time.Now()}
+
+	child.Lock()
+	// skip StartSpan/Timed as well as startSpan
+	child.logDepth(1, slog.LevelDebug, name+" started", args...)
+	child.Unlock()
+
+	return s
+}
+
+// Logger returns a logger that tags its records with this span
+func (s *Span) Logger() *Logger {
+	return s.logger
+}
+
+// ID returns the span's identifier
+func (s *Span) ID() string {
+	return s.logger.spanID
+}
+
+// CaptureError makes End attach the error errp points to at that time.
+// It returns the span so it can be chained onto StartSpan.
+func (s *Span) CaptureError(errp *error) *Span {
+	s.errp = errp
+	return s
+}
+
+// End logs the finish record.  Only the first call has any effect.
+func (s *Span) End() {
+	s.end()
+}
+
+func (s *Span) end() {
+	if !s.ended.CompareAndSwap(false, true) {
+		return
+	}
+	d := time.Since(s.start)
+
+	s.logger.Lock()
+	defer s.logger.Unlock()
+
+	level := slog.LevelInfo
+	args := append([]any{DurationKey, float64(d.Microseconds()) / 1000}, s.args...)
+	// SetSlowThreshold may be called on the span's logger concurrently
+	if t := s.logger.slowThreshold; t > 0 && d > t {
+		level = slog.LevelWarn
+		args = append(args, "slow", true, "threshold_ms", t.Milliseconds())
+	}
+	if s.errp != nil && *s.errp != nil {
+		level = slog.LevelError
+		args = append(args, "error", (*s.errp).Error())
+	}
+
+	// skip End or the Timed closure as well as end
+	s.logger.logDepth(1, level, s.name+" finished", args...)
+}
+
+func newSpanID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}